@@ -0,0 +1,519 @@
+package bot
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// adminPermissions are required to see /admin, guilds can still grant it to other roles in their settings
+var adminPermissions int64 = discordgo.PermissionAdministrator
+
+// adminRelation is a relation field pointing at a managed collection, moved over on merge
+type adminRelation struct {
+	Collection string
+	Field      string
+	Multiple   bool
+}
+
+// adminEntity is a collection /admin manages, along with its live mapping
+type adminEntity struct {
+	Name       string
+	Plural     string
+	Collection string
+	// lookup returns the IDs a lowercased name or alias maps to
+	lookup func(name string) []string
+	// reload refreshes the live mapping from the database
+	reload    func() error
+	relations []adminRelation
+}
+
+var adminEntities = map[string]adminEntity{
+	"idol": {
+		Name:       "idol",
+		Plural:     "idols",
+		Collection: "groups_idols",
+		lookup: func(name string) []string {
+			var ids []string
+			for _, idol := range idolMap.snapshot()[name] {
+				ids = append(ids, idol.ID)
+			}
+			return ids
+		},
+		reload: func() error {
+			return idolMap.reload(loadIdolsFromDB)
+		},
+		relations: []adminRelation{
+			{"contents", "idol", true},
+			{"contents_sets", "idol", true},
+			{"discord_roles", "idol", false},
+		},
+	},
+	"group": {
+		Name:       "group",
+		Plural:     "groups",
+		Collection: "groups",
+		lookup:     mapLookup(&groupMap),
+		reload: func() error {
+			if err := groupMap.reload(loadGroupsFromDB); err != nil {
+				return err
+			}
+			return groupNames.reload(loadNamesFromDB("groups"))
+		},
+		relations: []adminRelation{
+			{"contents", "group", true},
+			{"contents_sets", "group", true},
+			{"groups_idols", "group", false},
+			{"discord_roles", "group", false},
+		},
+	},
+	"tag": {
+		Name:       "tag",
+		Plural:     "tags",
+		Collection: "tags",
+		lookup:     mapLookup(&tagMap),
+		reload: func() error {
+			if err := tagMap.reload(loadTagsFromDB); err != nil {
+				return err
+			}
+			return tagNames.reload(loadNamesFromDB("tags"))
+		},
+		relations: []adminRelation{
+			{"contents", "tag", true},
+		},
+	},
+}
+
+// mapLookup looks names up in one of the name -> ID mappings, read on every call since reloads replace them
+func mapLookup(m *nameMapping[string]) func(string) []string {
+	return func(name string) []string {
+		if id, ok := m.get(name); ok {
+			return []string{id}
+		}
+		return nil
+	}
+}
+
+var adminCommand = &discordgo.ApplicationCommand{
+	Name:                     "admin",
+	Description:              "Manage the idols, groups and tags of the KpopCat archive and the roles standing for them.",
+	DefaultMemberPermissions: &adminPermissions,
+	Options: []*discordgo.ApplicationCommandOption{
+		adminEntityOptions("idol"),
+		adminEntityOptions("group"),
+		adminEntityOptions("tag"),
+		adminRoleOptions(),
+	},
+}
+
+// adminEntityOptions returns the add, rename, alias and merge subcommands of a managed collection
+func adminEntityOptions(entity string) *discordgo.ApplicationCommandOption {
+	target := func(description string) *discordgo.ApplicationCommandOption {
+		return &discordgo.ApplicationCommandOption{
+			Name:         entity,
+			Description:  description,
+			Type:         discordgo.ApplicationCommandOptionString,
+			Required:     true,
+			Autocomplete: true,
+		}
+	}
+
+	addOptions := []*discordgo.ApplicationCommandOption{
+		{
+			Name:        "name",
+			Description: "Name of the " + entity,
+			Type:        discordgo.ApplicationCommandOptionString,
+			Required:    true,
+		},
+		{
+			Name:        "code",
+			Description: "Short code, defaults to the name in lowercase with dashes",
+			Type:        discordgo.ApplicationCommandOptionString,
+		},
+	}
+	if entity == "idol" {
+		addOptions = append(addOptions, &discordgo.ApplicationCommandOption{
+			Name:         "group",
+			Description:  "Group of the idol",
+			Type:         discordgo.ApplicationCommandOptionString,
+			Autocomplete: true,
+		})
+	}
+
+	return &discordgo.ApplicationCommandOption{
+		Name:        entity,
+		Description: "Manage " + adminEntities[entity].Plural,
+		Type:        discordgo.ApplicationCommandOptionSubCommandGroup,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Name:        "add",
+				Description: "Add a new " + entity,
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Options:     addOptions,
+			},
+			{
+				Name:        "rename",
+				Description: "Rename a " + entity + ", the old name is kept as an alias",
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Options: []*discordgo.ApplicationCommandOption{
+					target("The " + entity + " to rename"),
+					{
+						Name:        "name",
+						Description: "New name",
+						Type:        discordgo.ApplicationCommandOptionString,
+						Required:    true,
+					},
+				},
+			},
+			{
+				Name:        "alias",
+				Description: "Add or remove another name the " + entity + " is found by",
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Options: []*discordgo.ApplicationCommandOption{
+					target("The " + entity + " to change"),
+					{
+						Name:        "alias",
+						Description: "The other name",
+						Type:        discordgo.ApplicationCommandOptionString,
+						Required:    true,
+					},
+					{
+						Name:        "remove",
+						Description: "Remove the alias instead of adding it",
+						Type:        discordgo.ApplicationCommandOptionBoolean,
+					},
+				},
+			},
+			{
+				Name:        "merge",
+				Description: "Move everything of a duplicate " + entity + " onto another one and delete it",
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Options: []*discordgo.ApplicationCommandOption{
+					target("The duplicate " + entity + " to delete"),
+					{
+						Name:         "into",
+						Description:  "The " + entity + " to keep",
+						Type:         discordgo.ApplicationCommandOptionString,
+						Required:     true,
+						Autocomplete: true,
+					},
+				},
+			},
+		},
+	}
+}
+
+func handleAdminCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.GuildID == "" || i.Member == nil {
+		respondEphemeral(s, i.Interaction, "/admin can only be used in a server.")
+		return
+	}
+
+	data := i.ApplicationCommandData()
+	if len(data.Options) == 0 || len(data.Options[0].Options) == 0 {
+		respondEphemeral(s, i.Interaction, "Pick what to manage.")
+		return
+	}
+	group := data.Options[0]
+	subcommand := group.Options[0]
+
+	if group.Name == "role" {
+		handleAdminRoleCommand(s, i, subcommand)
+		return
+	}
+
+	entity, ok := adminEntities[group.Name]
+	if !ok {
+		respondEphemeral(s, i.Interaction, "Unknown admin command.")
+		return
+	}
+
+	options := make(map[string]string)
+	remove := false
+	for _, opt := range subcommand.Options {
+		if opt.Type == discordgo.ApplicationCommandOptionBoolean {
+			remove = opt.BoolValue()
+			continue
+		}
+		options[opt.Name] = strings.TrimSpace(opt.StringValue())
+	}
+
+	var (
+		summary   string
+		recordIDs []string
+		err       error
+	)
+	switch subcommand.Name {
+	case "add":
+		summary, recordIDs, err = entity.add(options)
+	case "rename":
+		summary, recordIDs, err = entity.rename(options)
+	case "alias":
+		summary, recordIDs, err = entity.alias(options, remove)
+	case "merge":
+		summary, recordIDs, err = entity.merge(options)
+	default:
+		err = fmt.Errorf("Unknown admin command.")
+	}
+	if err != nil {
+		respondEphemeral(s, i.Interaction, err.Error())
+		return
+	}
+
+	// the mapping is rebuilt from the database, so aliases and renames apply right away.
+	// A merge also moves relations other mappings hold, like the group of every idol, so they are all rebuilt.
+	reload := entity.reload
+	if subcommand.Name == "merge" {
+		reload = initializeMappings
+	}
+	if err := reload(); err != nil {
+		slog.Error("UNABLE TO RELOAD MAPPINGS", "ENTITY", entity.Plural, "MSG", err)
+		summary += "\nThe change is saved but the bot could not reload its " + entity.Plural + ", it applies after a restart."
+	}
+
+	recordAudit(AuditEntry{
+		Action:    "admin:" + entity.Name + ":" + subcommand.Name,
+		ActorID:   getUserID(i),
+		GuildID:   i.GuildID,
+		ChannelID: i.ChannelID,
+		Records:   recordIDs,
+		Details:   map[string]any{"options": options, "remove": remove},
+	})
+
+	respondEphemeral(s, i.Interaction, summary)
+}
+
+// find returns the record a name, alias or record ID stands for
+func (e adminEntity) find(value string) (*core.Record, error) {
+	if value == "" {
+		return nil, fmt.Errorf("Give the %s to change.", e.Name)
+	}
+
+	// idols are suggested by ID since several can share a name
+	if record, err := App.FindRecordById(e.Collection, value); err == nil {
+		return record, nil
+	}
+
+	ids := e.lookup(strings.ToLower(value))
+	switch len(ids) {
+	case 0:
+		return nil, fmt.Errorf("Unknown %s: %s", e.Name, value)
+	case 1:
+		record, err := App.FindRecordById(e.Collection, ids[0])
+		if err != nil {
+			slog.Error("UNABLE TO LOAD RECORD", "ENTITY", e.Name, "ID", ids[0], "MSG", err)
+			return nil, fmt.Errorf("Could not query database.")
+		}
+		return record, nil
+	default:
+		return nil, fmt.Errorf("Several %s are named %s, pick one from the suggestions.", e.Plural, value)
+	}
+}
+
+// nameTaken reports whether another record of the collection already goes by a name.
+// Idols only clash within their group, idols of different groups often share a name.
+func (e adminEntity) nameTaken(name, exceptID, groupID string) bool {
+	for _, id := range e.lookup(strings.ToLower(name)) {
+		if id == exceptID {
+			continue
+		}
+		if e.Name != "idol" {
+			return true
+		}
+		for _, idol := range idolMap.snapshot()[strings.ToLower(name)] {
+			if idol.ID == id && idol.Group == groupID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (e adminEntity) add(options map[string]string) (string, []string, error) {
+	name := options["name"]
+	if name == "" {
+		return "", nil, fmt.Errorf("Give the name of the %s.", e.Name)
+	}
+
+	var groupID string
+	if groupName := options["group"]; groupName != "" {
+		id, ok := groupMap.get(strings.ToLower(groupName))
+		if !ok {
+			return "", nil, fmt.Errorf("Unknown group: %s", groupName)
+		}
+		groupID = id
+	}
+
+	if e.nameTaken(name, "", groupID) {
+		return "", nil, fmt.Errorf("%s already exists.", name)
+	}
+
+	code := options["code"]
+	if code == "" {
+		code = strings.ReplaceAll(strings.ToLower(name), " ", "-")
+	}
+
+	collection, err := App.FindCollectionByNameOrId(e.Collection)
+	if err != nil {
+		slog.Error("UNABLE TO FIND RECORDS", "COLLECTION", e.Collection, "MSG", err)
+		return "", nil, fmt.Errorf("Could not query database.")
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("name", name)
+	record.Set("code", code)
+	if groupID != "" {
+		record.Set("group", groupID)
+	}
+
+	if err := App.Save(record); err != nil {
+		slog.Error("UNABLE TO ADD RECORD", "ENTITY", e.Name, "NAME", name, "MSG", err)
+		return "", nil, fmt.Errorf("Could not save the %s: %v", e.Name, err)
+	}
+
+	return fmt.Sprintf("Added the %s **%s** (`%s`).", e.Name, name, code), []string{record.Id}, nil
+}
+
+func (e adminEntity) rename(options map[string]string) (string, []string, error) {
+	record, err := e.find(options[e.Name])
+	if err != nil {
+		return "", nil, err
+	}
+
+	name := options["name"]
+	if name == "" {
+		return "", nil, fmt.Errorf("Give the new name.")
+	}
+	if e.nameTaken(name, record.Id, record.GetString("group")) {
+		return "", nil, fmt.Errorf("%s already exists.", name)
+	}
+
+	// the old name keeps working, older messages and roles still use it
+	oldName := record.GetString("name")
+	if !strings.EqualFold(oldName, name) {
+		record.Set("aliases", appendAlias(recordAliases(record), oldName, name))
+	}
+	record.Set("name", name)
+
+	if err := App.Save(record); err != nil {
+		slog.Error("UNABLE TO RENAME RECORD", "ENTITY", e.Name, "ID", record.Id, "MSG", err)
+		return "", nil, fmt.Errorf("Could not save the %s: %v", e.Name, err)
+	}
+
+	return fmt.Sprintf("Renamed the %s **%s** to **%s**.", e.Name, oldName, name), []string{record.Id}, nil
+}
+
+func (e adminEntity) alias(options map[string]string, remove bool) (string, []string, error) {
+	record, err := e.find(options[e.Name])
+	if err != nil {
+		return "", nil, err
+	}
+
+	alias := strings.ToLower(options["alias"])
+	if alias == "" {
+		return "", nil, fmt.Errorf("Give the alias.")
+	}
+
+	name := record.GetString("name")
+	aliases := recordAliases(record)
+
+	if remove {
+		if !slices.Contains(aliases, alias) {
+			return "", nil, fmt.Errorf("%s is not an alias of %s.", alias, name)
+		}
+		record.Set("aliases", slices.DeleteFunc(aliases, func(a string) bool { return a == alias }))
+	} else {
+		if strings.EqualFold(alias, name) || slices.Contains(aliases, alias) {
+			return "", nil, fmt.Errorf("%s already goes by %s.", name, alias)
+		}
+		if e.nameTaken(alias, record.Id, record.GetString("group")) {
+			return "", nil, fmt.Errorf("Another %s already goes by %s.", e.Name, alias)
+		}
+		record.Set("aliases", appendAlias(aliases, alias, name))
+	}
+
+	if err := App.Save(record); err != nil {
+		slog.Error("UNABLE TO SAVE ALIASES", "ENTITY", e.Name, "ID", record.Id, "MSG", err)
+		return "", nil, fmt.Errorf("Could not save the %s: %v", e.Name, err)
+	}
+
+	if remove {
+		return fmt.Sprintf("Removed the alias **%s** from **%s**.", alias, name), []string{record.Id}, nil
+	}
+	return fmt.Sprintf("**%s** is now also found as **%s**.", name, alias), []string{record.Id}, nil
+}
+
+// merge moves every relation of the duplicate onto the kept record, which takes over the name and
+// aliases of the duplicate as aliases, then deletes the duplicate, all in a single transaction
+func (e adminEntity) merge(options map[string]string) (string, []string, error) {
+	duplicate, err := e.find(options[e.Name])
+	if err != nil {
+		return "", nil, err
+	}
+	keep, err := e.find(options["into"])
+	if err != nil {
+		return "", nil, err
+	}
+	if duplicate.Id == keep.Id {
+		return "", nil, fmt.Errorf("Pick two different %s to merge.", e.Plural)
+	}
+
+	moved := 0
+	err = App.RunInTransaction(func(txApp core.App) error {
+		for _, relation := range e.relations {
+			filter := relation.Field + " = {:id}"
+			if relation.Multiple {
+				filter = relation.Field + ":each ?= {:id}"
+			}
+
+			records, err := txApp.FindRecordsByFilter(relation.Collection, filter, "", 0, 0, dbx.Params{"id": duplicate.Id})
+			if err != nil {
+				return err
+			}
+			for _, record := range records {
+				if relation.Multiple {
+					record.Set(relation.Field+"-", duplicate.Id)
+					record.Set(relation.Field+"+", keep.Id)
+				} else {
+					record.Set(relation.Field, keep.Id)
+				}
+				if err := txApp.Save(record); err != nil {
+					return err
+				}
+			}
+			moved += len(records)
+		}
+
+		aliases := recordAliases(keep)
+		for _, alias := range append([]string{duplicate.GetString("name")}, recordAliases(duplicate)...) {
+			aliases = appendAlias(aliases, alias, keep.GetString("name"))
+		}
+		keep.Set("aliases", aliases)
+
+		if err := txApp.Delete(duplicate); err != nil {
+			return err
+		}
+		return txApp.Save(keep)
+	})
+	if err != nil {
+		slog.Error("UNABLE TO MERGE RECORDS", "ENTITY", e.Name, "DUPLICATE", duplicate.Id, "KEEP", keep.Id, "MSG", err)
+		return "", nil, fmt.Errorf("Could not merge the %s: %v", e.Plural, err)
+	}
+
+	return fmt.Sprintf("Merged **%s** into **%s**, %d records moved.", duplicate.GetString("name"), keep.GetString("name"), moved),
+		[]string{keep.Id, duplicate.Id}, nil
+}
+
+// appendAlias adds a lowercased alias unless it's empty, already there or the name itself
+func appendAlias(aliases []string, alias, name string) []string {
+	alias = strings.ToLower(strings.TrimSpace(alias))
+	if alias == "" || strings.EqualFold(alias, name) || slices.Contains(aliases, alias) {
+		return aliases
+	}
+	return append(aliases, alias)
+}
@@ -0,0 +1,30 @@
+package bot
+
+import (
+	"log/slog"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// recordAudit stores a moderation action in "audit_logs".
+// Failures are only logged, they never block the action itself.
+func recordAudit(entry AuditEntry) {
+	collection, err := App.FindCollectionByNameOrId("audit_logs")
+	if err != nil {
+		slog.Error("ERROR FINDING COLLECTION", "MSG", err)
+		return
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("action", entry.Action)
+	record.Set("actorId", entry.ActorID)
+	record.Set("guildId", entry.GuildID)
+	record.Set("channelId", entry.ChannelID)
+	record.Set("messageId", entry.MessageID)
+	record.Set("records", entry.Records)
+	record.Set("details", entry.Details)
+
+	if err := App.Save(record); err != nil {
+		slog.Error("ERROR SAVING AUDIT LOG", "ACTION", entry.Action, "MSG", err)
+	}
+}
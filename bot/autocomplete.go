@@ -0,0 +1,244 @@
+package bot
+
+import (
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// maxAutocompleteChoices is the most choices Discord accepts in an autocomplete response
+const maxAutocompleteChoices = 25
+
+// autocompleteSource returns the suggestions for a focused option.
+// options holds the other options the user already filled in, for context.
+type autocompleteSource func(typed string, options map[string]string) []suggestion
+
+// suggestion is a single autocomplete choice with its label and submitted value
+type suggestion struct {
+	Label string
+	Value string
+}
+
+// autocompleteSources maps option names to their suggestions, so any option named
+// "idol", "group", "tag" or "uploader" in any command gets autocomplete by setting Autocomplete: true.
+// A "command.option" key takes precedence over the plain option name, and a key with the full path
+// through subcommands like "admin.idol.merge.into" takes precedence over both.
+var autocompleteSources = map[string]autocompleteSource{
+	"idol":                   idolSuggestions,
+	"group":                  groupSuggestions,
+	"tag":                    tagSuggestions,
+	"uploader":               uploaderSuggestions,
+	"admin.idol":             idolIDSuggestions,
+	"admin.idol.merge.into":  idolIDSuggestions,
+	"admin.group.merge.into": groupSuggestions,
+	"admin.tag.merge.into":   tagSuggestions,
+}
+
+// handleAutocomplete answers autocomplete interactions of every command
+func handleAutocomplete(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	data := i.ApplicationCommandData()
+	focused, siblings, path := findFocusedOption(data.Options)
+	if focused == nil {
+		return
+	}
+
+	source, ok := autocompleteSources[data.Name+"."+strings.Join(path, ".")]
+	if !ok {
+		source, ok = autocompleteSources[data.Name+"."+focused.Name]
+	}
+	if !ok {
+		source, ok = autocompleteSources[focused.Name]
+	}
+
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	if ok {
+		options := make(map[string]string, len(siblings))
+		for _, opt := range siblings {
+			if opt.Type == discordgo.ApplicationCommandOptionString {
+				options[opt.Name] = opt.StringValue()
+			}
+		}
+
+		// comma separated lists like "Yujin, Wonyoung" complete their last entry
+		typed := focused.StringValue()
+		prefix := ""
+		if idx := strings.LastIndex(typed, ","); idx != -1 {
+			prefix = typed[:idx+1] + " "
+			typed = typed[idx+1:]
+		}
+
+		for _, sug := range source(strings.TrimSpace(typed), options) {
+			value := prefix + sug.Value
+			// Discord rejects choices longer than 100 characters
+			if len(value) > 100 || len(prefix+sug.Label) > 100 {
+				continue
+			}
+			choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: prefix + sug.Label, Value: value})
+			if len(choices) == maxAutocompleteChoices {
+				break
+			}
+		}
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &discordgo.InteractionResponseData{
+			Choices: choices,
+		},
+	})
+	if err != nil {
+		log.Printf("Error responding to /%s %s autocomplete: %v", data.Name, strings.Join(path, " "), err)
+	}
+}
+
+// findFocusedOption returns the focused option and the options next to it,
+// looking through subcommands and subcommand groups
+func findFocusedOption(options []*discordgo.ApplicationCommandInteractionDataOption) (*discordgo.ApplicationCommandInteractionDataOption, []*discordgo.ApplicationCommandInteractionDataOption, []string) {
+	for _, opt := range options {
+		if opt.Focused {
+			return opt, options, []string{opt.Name}
+		}
+		if opt.Type == discordgo.ApplicationCommandOptionSubCommand || opt.Type == discordgo.ApplicationCommandOptionSubCommandGroup {
+			if focused, siblings, path := findFocusedOption(opt.Options); focused != nil {
+				return focused, siblings, append([]string{opt.Name}, path...)
+			}
+		}
+	}
+	return nil, nil, nil
+}
+
+// idolSuggestions suggests idol names, limited to the idols of the chosen group if there is one
+func idolSuggestions(typed string, options map[string]string) []suggestion {
+	return rankIdols(typed, options, func(idol IdolItem) string { return idol.Name })
+}
+
+// idolIDSuggestions suggests idols with their record ID as the value, for commands that need
+// to tell apart idols sharing a name
+func idolIDSuggestions(typed string, options map[string]string) []suggestion {
+	return rankIdols(typed, options, func(idol IdolItem) string { return idol.ID })
+}
+
+// rankIdols ranks the idols matching what was typed, labelled with their group
+func rankIdols(typed string, options map[string]string, value func(IdolItem) string) []suggestion {
+	names := groupNames.snapshot()
+
+	var contextGroupIDs map[string]bool
+	if group := options["group"]; group != "" {
+		contextGroupIDs = createGroupIDSet(group, groupMap.snapshot())
+	}
+
+	var candidates []rankedSuggestion
+	for key, idols := range idolMap.snapshot() {
+		score, ok := rankMatch(key, typed)
+		if !ok {
+			continue
+		}
+		for _, idol := range idols {
+			if len(contextGroupIDs) > 0 && !contextGroupIDs[idol.Group] {
+				continue
+			}
+			label := idol.Name
+			if groupName, ok := names[idol.Group]; ok && groupName != "" {
+				label += " (" + groupName + ")"
+			}
+			candidates = append(candidates, rankedSuggestion{suggestion{Label: label, Value: value(idol)}, score})
+		}
+	}
+
+	return sortSuggestions(candidates)
+}
+
+// groupSuggestions suggests group names, matching their aliases as well
+func groupSuggestions(typed string, options map[string]string) []suggestion {
+	return recordSuggestions(groupMap.snapshot(), groupNames.snapshot(), typed)
+}
+
+// tagSuggestions suggests tag names, matching their codes and aliases as well
+func tagSuggestions(typed string, options map[string]string) []suggestion {
+	return recordSuggestions(tagMap.snapshot(), tagNames.snapshot(), typed)
+}
+
+// uploaderSuggestions suggests uploader names
+func uploaderSuggestions(typed string, options map[string]string) []suggestion {
+	return recordSuggestions(uploaderMap.snapshot(), nil, typed)
+}
+
+// recordSuggestions matches what was typed against the keys of one of the name -> ID mappings and suggests
+// each matching record once, by its name in names. Records missing from names are suggested by their key.
+func recordSuggestions(m map[string]string, names map[string]string, typed string) []suggestion {
+	best := make(map[string]rankedSuggestion)
+	for key, id := range m {
+		score, ok := rankMatch(key, typed)
+		if !ok {
+			continue
+		}
+
+		name := names[id]
+		if name == "" {
+			name = key
+		}
+		// a record is matched by its name, its code and its aliases, only its best match counts
+		if current, seen := best[id]; seen && current.score <= score {
+			continue
+		}
+		best[id] = rankedSuggestion{suggestion{Label: name, Value: name}, score}
+	}
+
+	candidates := make([]rankedSuggestion, 0, len(best))
+	for _, candidate := range best {
+		candidates = append(candidates, candidate)
+	}
+	return sortSuggestions(candidates)
+}
+
+type rankedSuggestion struct {
+	suggestion
+	score int
+}
+
+// rankMatch scores how well a name matches what was typed, lower is better:
+// exact match, prefix, prefix of a later word, anywhere in the name
+func rankMatch(name, typed string) (int, bool) {
+	name = strings.ToLower(name)
+	typed = strings.ToLower(typed)
+
+	switch {
+	case typed == "":
+		return 3, true
+	case name == typed:
+		return 0, true
+	case strings.HasPrefix(name, typed):
+		return 1, true
+	case strings.Contains(name, " "+typed) || strings.Contains(name, "-"+typed):
+		return 2, true
+	case strings.Contains(name, typed):
+		return 3, true
+	}
+	return 0, false
+}
+
+// sortSuggestions orders suggestions by score, then shorter labels, then alphabetically, without repeats
+func sortSuggestions(candidates []rankedSuggestion) []suggestion {
+	sort.Slice(candidates, func(a, b int) bool {
+		if candidates[a].score != candidates[b].score {
+			return candidates[a].score < candidates[b].score
+		}
+		if len(candidates[a].Label) != len(candidates[b].Label) {
+			return len(candidates[a].Label) < len(candidates[b].Label)
+		}
+		return candidates[a].Label < candidates[b].Label
+	})
+
+	// aliases match the same names again, only their best match is kept
+	result := make([]suggestion, 0, len(candidates))
+	seen := make(map[suggestion]bool, len(candidates))
+	for _, candidate := range candidates {
+		if !seen[candidate.suggestion] {
+			seen[candidate.suggestion] = true
+			result = append(result, candidate.suggestion)
+		}
+	}
+	return result
+}
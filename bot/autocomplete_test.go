@@ -0,0 +1,25 @@
+package bot
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestGroupSuggestionsUseRecordNames(t *testing.T) {
+	app := newTestApp(t)
+
+	group := createTestRecord(t, app, "groups", map[string]any{"name": "NewJeans", "aliases": []string{"NJ", "Newjeans"}})
+	createTestRecord(t, app, "groups_idols", map[string]any{"name": "Hyein", "group": group.Id, "aliases": []string{"Hyeinnie"}})
+	createTestRecord(t, app, "tags", map[string]any{"name": "Fancam", "code": "fancam-video"})
+	loadTestMappings(t)
+
+	if got, want := groupSuggestions("n", nil), []suggestion{{"NewJeans", "NewJeans"}}; !slices.Equal(got, want) {
+		t.Errorf("groups = %v, want %v", got, want)
+	}
+	if got, want := tagSuggestions("fan", nil), []suggestion{{"Fancam", "Fancam"}}; !slices.Equal(got, want) {
+		t.Errorf("tags = %v, want %v", got, want)
+	}
+	if got, want := idolSuggestions("hye", nil), []suggestion{{"Hyein (NewJeans)", "Hyein"}}; !slices.Equal(got, want) {
+		t.Errorf("idols = %v, want %v", got, want)
+	}
+}
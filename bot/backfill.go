@@ -0,0 +1,166 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"kcat-v3-be/bot/utils"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// backfillBatchSize is how many records are loaded at once while backfilling
+const backfillBatchSize = 500
+
+// backfillFilter selects the records whose Discord fields can still be filled: those without a message ID,
+// and those without an author whose uploader is linked to a Discord account
+const backfillFilter = "discord != '' && (discordMessageId = '' || (discordAuthorId = '' && uploader.discordId ?!= ''))"
+
+// startBackfill runs backfillDiscordRefs in the background, Stop cancels it and waits for the batch in progress.
+// lifecycleMu must be held.
+func startBackfill() {
+	if !ingests.Start() {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelBackfill = cancel
+
+	go func() {
+		defer ingests.Done()
+		defer cancel()
+		if err := backfillDiscordRefs(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			slog.Error("UNABLE TO BACKFILL DISCORD REFERENCES", "MSG", err)
+		}
+	}()
+}
+
+// backfillDiscordRefs fills the structured Discord fields of records created before they existed
+// by parsing their "discord" message link. The link has no author, the author is the Discord account
+// of the uploader when it is linked to one. Sets get the reference of their oldest item.
+// Only records missing something are loaded, and it stops between batches when ctx is done.
+func backfillDiscordRefs(ctx context.Context) error {
+	updated := 0
+	skipped := 0
+
+	// paging past the last record never loads a record twice, records that can't be completed keep matching the filter
+	var afterCreated, afterID string
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		records, err := App.FindRecordsByFilter(
+			"contents",
+			backfillFilter+" && (created > {:created} || (created = {:created} && id > {:id}))",
+			"created,id",
+			backfillBatchSize,
+			0,
+			dbx.Params{"created": afterCreated, "id": afterID},
+		)
+		if err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			break
+		}
+		last := records[len(records)-1]
+		afterCreated, afterID = last.GetDateTime("created").String(), last.Id
+
+		if errs := App.ExpandRecords(records, []string{"uploader"}, nil); len(errs) > 0 {
+			slog.Warn("UNABLE TO EXPAND UPLOADERS FOR BACKFILL", "MSG", errs)
+		}
+
+		for _, record := range records {
+			ref, ok := backfillDiscordRef(record)
+			if !ok {
+				skipped++
+				continue
+			}
+
+			if err := App.Save(record); err != nil {
+				slog.Error("ERROR SAVING RECORD", "MSG", err)
+				skipped++
+				continue
+			}
+			updated++
+
+			if setID := record.GetString("set"); setID != "" {
+				backfillSetDiscordRef(setID, ref)
+			}
+		}
+
+		if len(records) < backfillBatchSize {
+			break
+		}
+	}
+
+	if updated > 0 || skipped > 0 {
+		slog.Info("✅ Backfilled Discord references", "updated", updated, "skipped", skipped)
+	}
+
+	return nil
+}
+
+// backfillDiscordRef fills the missing Discord fields of a record and returns its reference,
+// false when nothing could be filled
+func backfillDiscordRef(record *core.Record) (DiscordRef, bool) {
+	ref := DiscordRef{
+		GuildID:   record.GetString("discordGuildId"),
+		ChannelID: record.GetString("discordChannelId"),
+		MessageID: record.GetString("discordMessageId"),
+		AuthorID:  record.GetString("discordAuthorId"),
+	}
+	changed := false
+
+	if ref.MessageID == "" {
+		guildID, channelID, messageID, ok := utils.ParseDiscordMessageLink(record.GetString("discord"))
+		if !ok {
+			slog.Warn("UNABLE TO PARSE DISCORD LINK", "RECORD", record.Id, "LINK", record.GetString("discord"))
+		} else {
+			ref.GuildID, ref.ChannelID, ref.MessageID = guildID, channelID, messageID
+			record.Set("discordGuildId", guildID)
+			record.Set("discordChannelId", channelID)
+			record.Set("discordMessageId", messageID)
+			changed = true
+		}
+	}
+
+	if ref.AuthorID == "" {
+		for _, uploader := range record.ExpandedAll("uploader") {
+			if discordID := uploader.GetString("discordId"); discordID != "" {
+				ref.AuthorID = discordID
+				record.Set("discordAuthorId", discordID)
+				changed = true
+				break
+			}
+		}
+	}
+
+	return ref, changed
+}
+
+// backfillSetDiscordRef fills the Discord fields a set is missing
+func backfillSetDiscordRef(setID string, ref DiscordRef) {
+	set, err := App.FindFirstRecordByFilter(
+		"contents_sets",
+		"id = {:id} && (discordMessageId = '' || discordAuthorId = '')",
+		dbx.Params{"id": setID},
+	)
+	if err != nil {
+		return // missing or already filled by an older item
+	}
+
+	if set.GetString("discordMessageId") == "" && ref.MessageID != "" {
+		set.Set("discordGuildId", ref.GuildID)
+		set.Set("discordChannelId", ref.ChannelID)
+		set.Set("discordMessageId", ref.MessageID)
+	}
+	if set.GetString("discordAuthorId") == "" && ref.AuthorID != "" {
+		set.Set("discordAuthorId", ref.AuthorID)
+	}
+	if err := App.Save(set); err != nil {
+		slog.Error("ERROR SAVING RECORD", "MSG", err)
+	}
+}
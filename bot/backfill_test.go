@@ -0,0 +1,74 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestBackfillDiscordRefs(t *testing.T) {
+	app := newTestApp(t)
+
+	group := createTestRecord(t, app, "groups", map[string]any{"name": "IVE"})
+	idol := createTestRecord(t, app, "groups_idols", map[string]any{"name": "Yujin", "group": group.Id})
+	required := func(fields map[string]any) map[string]any {
+		fields["idol"] = []string{idol.Id}
+		fields["group"] = []string{group.Id}
+		return fields
+	}
+
+	linked := createTestRecord(t, app, "uploaders", map[string]any{"name": "linked", "discordId": "111"})
+	unlinked := createTestRecord(t, app, "uploaders", map[string]any{"name": "unlinked"})
+	set := createTestRecord(t, app, "contents_sets", required(map[string]any{"title": "set"}))
+
+	link := "https://discord.com/channels/1/2/3"
+	inSet := createTestRecord(t, app, "contents", required(map[string]any{"title": "in set", "discord": link, "uploader": []string{linked.Id}, "set": set.Id}))
+	noAuthor := createTestRecord(t, app, "contents", required(map[string]any{"title": "no author", "discord": link, "uploader": []string{unlinked.Id}}))
+	authorOnly := createTestRecord(t, app, "contents", required(map[string]any{"title": "author only", "discord": link, "uploader": []string{linked.Id},
+		"discordGuildId": "1", "discordChannelId": "2", "discordMessageId": "3"}))
+	broken := createTestRecord(t, app, "contents", required(map[string]any{"title": "broken", "discord": "https://example.com/nope", "uploader": []string{unlinked.Id}}))
+
+	if err := backfillDiscordRefs(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		collection string
+		id         string
+		message    string
+		author     string
+	}{
+		{"contents", inSet.Id, "3", "111"},
+		{"contents", noAuthor.Id, "3", ""},
+		{"contents", authorOnly.Id, "3", "111"},
+		{"contents", broken.Id, "", ""},
+		{"contents_sets", set.Id, "3", "111"},
+	}
+	for _, tt := range tests {
+		record, err := app.FindRecordById(tt.collection, tt.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := record.GetString("discordMessageId"); got != tt.message {
+			t.Errorf("%s: message = %q, want %q", record.GetString("title"), got, tt.message)
+		}
+		if got := record.GetString("discordAuthorId"); got != tt.author {
+			t.Errorf("%s: author = %q, want %q", record.GetString("title"), got, tt.author)
+		}
+	}
+
+	// only the broken link is left, and a cancelled backfill stops before loading anything
+	remaining, err := app.FindRecordsByFilter("contents", backfillFilter, "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != 1 || remaining[0].Id != broken.Id {
+		t.Errorf("%d records left to backfill, want only the broken one", len(remaining))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := backfillDiscordRefs(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("error = %v, want %v", err, context.Canceled)
+	}
+}
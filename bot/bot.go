@@ -1,12 +1,13 @@
 package bot
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"os"
 	"path"
 	"regexp"
-	"strings"
+	"sync"
 
 	"kcat-v3-be/bot/utils"
 
@@ -16,13 +17,35 @@ import (
 
 // GLOBAL VARIABLES
 var imgurRegexp = regexp.MustCompile(`https?://(i\.)?imgur\.com/([a-zA-Z0-9]+)(\.[a-zA-Z0-9]+)?`)
+var imgurAlbumRegexp = regexp.MustCompile(`https?://(?:www\.|m\.)?imgur\.com/(a|gallery|t/[a-zA-Z0-9_]+)/([a-zA-Z0-9-]+)`)
 var roleRegexp = regexp.MustCompile(`(\w+) \[([^\]]+)\]`)
 var youtubeRegexp = regexp.MustCompile(`(?:https?://)?(?:www\.)?(?:youtube\.com/watch\?v=|youtu\.be/)[\w\-]{11}`)
 var pixeldrainRegexp = regexp.MustCompile(`(?:https?://)?(?:www\.)?pixeldrain\.com/(?:u|l)/[a-zA-Z0-9]+`)
 
-var idolMap map[string][]IdolItem
-var groupMap map[string]string
-var uploaderMap map[string]string
+var idolMap nameMapping[[]IdolItem]
+var groupMap nameMapping[string]
+var uploaderMap nameMapping[string]
+var tagMap nameMapping[string]
+
+// groupNames and tagNames are the names of groups and tags by record ID, as they are written in the database
+var groupNames nameMapping[string]
+var tagNames nameMapping[string]
+
+// session is the open Discord session, nil until Start succeeds
+var session *discordgo.Session
+
+// lifecycleMu keeps Stop from running while Start is still connecting
+var lifecycleMu sync.Mutex
+
+// stopped is set by Stop, so a Start that was still waiting on lifecycleMu doesn't connect after shutdown.
+// lifecycleMu must be held.
+var stopped bool
+
+// ingests are the messages and uploads being ingested, Stop waits for them
+var ingests utils.Inflight
+
+// cancelBackfill stops the backfill started by Start, nil when none is running
+var cancelBackfill context.CancelFunc
 
 var allowedChannelIDs = map[string]bool{
 	"124767749099618304":  true,
@@ -30,36 +53,53 @@ var allowedChannelIDs = map[string]bool{
 }
 
 func initializeMappings() error {
-	var err error
-
 	// Load groups from database
-	groupMap, err = loadGroupsFromDB()
-	if err != nil {
+	if err := groupMap.reload(loadGroupsFromDB); err != nil {
 		slog.Error("UNABLE TO LOAD GROUPS FROM DB: ", "MSG", err)
 		return err
 	}
+	if err := groupNames.reload(loadNamesFromDB("groups")); err != nil {
+		slog.Error("UNABLE TO LOAD GROUP NAMES FROM DB: ", "MSG", err)
+		return err
+	}
 
 	// Load uploaders from database
-	uploaderMap, err = loadUploadersFromDB()
-	if err != nil {
+	if err := uploaderMap.reload(loadUploadersFromDB); err != nil {
 		slog.Error("UNABLE TO LOAD UPLOADERS FROM DB: ", "MSG", err)
 		return err
 	}
 
 	// Load idols from database
-	idolMap, err = loadIdolsFromDB()
-	if err != nil {
+	if err := idolMap.reload(loadIdolsFromDB); err != nil {
 		slog.Error("UNABLE TO LOAD IDOLS FROM DB: ", "MSG", err)
 		return err
 	}
 
-	slog.Info("✅ Mappings initialized from database", "groups", len(groupMap), "uploaders", len(uploaderMap), "idols", len(idolMap))
+	// Load tags from database
+	if err := tagMap.reload(loadTagsFromDB); err != nil {
+		slog.Error("UNABLE TO LOAD TAGS FROM DB: ", "MSG", err)
+		return err
+	}
+	if err := tagNames.reload(loadNamesFromDB("tags")); err != nil {
+		slog.Error("UNABLE TO LOAD TAG NAMES FROM DB: ", "MSG", err)
+		return err
+	}
+
+	slog.Info("✅ Mappings initialized from database", "groups", len(groupMap.snapshot()), "uploaders", len(uploaderMap.snapshot()), "idols", len(idolMap.snapshot()), "tags", len(tagMap.snapshot()))
 	return nil
 }
 
 // Start initializes and starts the Discord bot
 // It takes a Pocketbase app instance to use for internal database operations
 func Start(pbApp *pocketbase.PocketBase) error {
+	lifecycleMu.Lock()
+	defer lifecycleMu.Unlock()
+
+	if stopped {
+		slog.Info("Discord bot was stopped before it started, not starting it")
+		return nil
+	}
+
 	// Store the Pocketbase app globally for use in helpers
 	App = pbApp
 
@@ -81,21 +121,75 @@ func Start(pbApp *pocketbase.PocketBase) error {
 		return err
 	}
 
+	// edits, deletes and reactions look records up by message ID, old records get theirs in the background
+	startBackfill()
+
+	configureRateLimits()
+	configureDownloadLimit()
+	registerPaginationCleanup(dg)
+
 	dg.AddHandler(messageCreate)
+	dg.AddHandler(messageUpdate)
+	dg.AddHandler(messageDelete)
+	dg.AddHandler(messageDeleteBulk)
+	dg.AddHandler(messageReactionAdd)
 	dg.AddHandler(commandUsed)
 
 	err = dg.Open()
 	if err != nil {
 		slog.Error("UNABLE TO OPEN DISCORD SESSION", "MSG", err)
+		unregisterPaginationCleanup()
 		return err
 	}
 
-	registerSlashCommands(dg)
+	session = dg
+
+	// commands that failed to register keep their previous definition, the bot still runs
+	if err := registerSlashCommands(dg); err != nil {
+		slog.Error("UNABLE TO REGISTER SLASH COMMANDS", "MSG", err)
+	}
+	registerCommandPermissionHooks()
 
 	slog.Info("Discord bot is now running")
 	return nil
 }
 
+// Stop closes the Discord session so no new messages come in, then waits for the ingests in progress
+// until ctx is done. The slash commands stay registered so they don't have to be registered again
+// on the next start, unless DISCORD_REMOVE_COMMANDS_ON_STOP is "true".
+func Stop(ctx context.Context) error {
+	lifecycleMu.Lock()
+	defer lifecycleMu.Unlock()
+
+	stopped = true
+
+	if session != nil {
+		unregisterPaginationCleanup()
+
+		if os.Getenv("DISCORD_REMOVE_COMMANDS_ON_STOP") == "true" {
+			RemoveCommands(session)
+		}
+
+		if err := session.Close(); err != nil {
+			slog.Error("UNABLE TO CLOSE DISCORD SESSION", "MSG", err)
+		}
+		session = nil
+	}
+
+	if cancelBackfill != nil {
+		cancelBackfill()
+		cancelBackfill = nil
+	}
+
+	if err := ingests.Wait(ctx); err != nil {
+		slog.Error("INGESTS STILL RUNNING AT SHUTDOWN", "MSG", err)
+		return err
+	}
+
+	slog.Info("Discord bot stopped")
+	return nil
+}
+
 func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
 	// Guard against malformed/unsupported events that may produce nil fields
 	if m == nil || m.Message == nil || m.Author == nil {
@@ -105,15 +199,15 @@ func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
 		return
 	}
 
-	var imgurLinks []string
+	var mediaLinks []MediaLink
 	metadata := Metadata{}
 	isReply := false
 
 	// bot reacts if its mentioned, there are roles pinged or if it's a reply
 	// for ping roles and replies, we need to check if the channel is allowed
 	if utils.BotIsMentioned(s, m) {
-		imgurLinks = retrieveImgurLinks(m.Content)
-		if len(imgurLinks) < 1 && len(m.Attachments) < 1 {
+		mediaLinks = findMediaLinks(m.Content)
+		if len(mediaLinks) < 1 && len(m.Attachments) < 1 {
 			return
 		}
 		err := extractMetadata(m.Content, &metadata)
@@ -122,30 +216,27 @@ func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
 			return
 		}
 	} else if len(m.MentionRoles) > 0 && allowedChannelIDs[m.ChannelID] {
-		imgurLinks = retrieveImgurLinks(m.Content)
-		if len(imgurLinks) < 1 && len(m.Attachments) < 1 {
+		mediaLinks = findMediaLinks(m.Content)
+		if len(mediaLinks) < 1 && len(m.Attachments) < 1 {
 			return
 		}
 
-		pingRoleNames, err := getRoleNamesFromIDs(s, m.GuildID, m.MentionRoles)
-		if err != nil {
-			slog.Error("UNABLE TO GET ROLE NAMES", "MSG", err)
-			return
-		} else if len(pingRoleNames) < 1 {
-			slog.Info("PING ROLES NOT FOUND", "MSG", pingRoleNames)
+		idolGroups := idolGroupsFromRoles(s, m.GuildID, m.MentionRoles)
+		if len(idolGroups) < 1 {
+			slog.Info("PING ROLES NOT FOUND", "MSG", m.MentionRoles)
 			return
 		}
 
-		metadata = createMetadata(pingRoleNames)
-		err = extractMetadata(m.Content, &metadata)
+		metadata = createMetadata(idolGroups)
+		err := extractMetadata(m.Content, &metadata)
 		if err != nil {
 			slog.Error("ERROR EXTRACTING METADATA", "MSG", err)
 			return
 		}
 	} else if m.Message.ReferencedMessage != nil && allowedChannelIDs[m.ChannelID] {
 		if m.Author.ID == lastMetadata.AuthorID && m.Message.ReferencedMessage.ID == lastMetadata.MessageID {
-			imgurLinks = retrieveImgurLinks(m.Content)
-			if len(imgurLinks) < 1 && len(m.Attachments) < 1 {
+			mediaLinks = findMediaLinks(m.Content)
+			if len(mediaLinks) < 1 && len(m.Attachments) < 1 {
 				return
 			}
 			isReply = true
@@ -155,9 +246,27 @@ func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
 		return
 	}
 
+	// floods are dropped before resolving any link, the hourglass tells the uploader to post again later
+	if !allowIngest(m.Author.ID, m.GuildID) {
+		slog.Warn("INGEST RATE LIMITED", "USER", m.Author.ID, "GUILD", m.GuildID)
+		if err := s.MessageReactionAdd(m.ChannelID, m.ID, "⏳"); err != nil {
+			slog.Error("UNABLE TO REACT TO RATE LIMITED MESSAGE", "MSG", err)
+		}
+		return
+	}
+
 	metadata.Uploader = m.Author.Username
-	metadata.Discord = fmt.Sprintf("https://discord.com/channels/%s/%s/%s", m.GuildID, m.ChannelID, m.ID)
-	totalItems := len(m.Attachments) + len(imgurLinks)
+	metadata.UploaderID = m.Author.ID
+	metadata.Discord = utils.GenerateDiscordMessageLink(m.GuildID, m.ChannelID, m.ID)
+	metadata.DiscordRef = DiscordRef{
+		GuildID:   m.GuildID,
+		ChannelID: m.ChannelID,
+		MessageID: m.ID,
+		AuthorID:  m.Author.ID,
+	}
+
+	resolvedMedia, resolveErr := resolveMediaLinks(mediaLinks)
+	totalItems := len(m.Attachments) + len(resolvedMedia)
 
 	if totalItems > 1 {
 		if !isReply {
@@ -176,31 +285,77 @@ func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
 		}
 	}
 
-	// Now create each item in "contents"
-	// 1) handle Discord attachments
-	for _, attach := range m.Attachments {
-		if strings.HasPrefix(attach.ContentType, "image/") {
-			metadata.Filetype = "image"
-		} else if strings.HasPrefix(attach.ContentType, "video/") {
-			metadata.Filetype = "video"
+	recordIDs := ingestMedia(metadata, m.Attachments, resolvedMedia)
+
+	// replies add to the set of the previous post, only what went wrong is worth a message
+	notice := unresolvedNotice(resolveErr)
+	if isReply {
+		if notice != "" {
+			replyToUploader(s, m, "⚠️ "+notice, nil, nil)
 		}
-		_, err := processMediaLinks(attach.URL, attach.Filename, metadata)
+		return
+	}
+
+	content, embeds, components := uploadSummary(metadata, recordIDs, totalItems)
+	if notice != "" {
+		content += "\n⚠️ " + notice
+	}
+	replyToUploader(s, m, content, embeds, components)
+}
+
+// replyToUploader answers an upload post without pinging its author
+func replyToUploader(s *discordgo.Session, m *discordgo.MessageCreate, content string, embeds []*discordgo.MessageEmbed, components []discordgo.MessageComponent) {
+	_, err := s.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{
+		Content:         content,
+		Embeds:          embeds,
+		Components:      components,
+		Reference:       m.Reference(),
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	})
+	if err != nil {
+		slog.Error("UNABLE TO REPLY TO UPLOADER", "MSG", err)
+	}
+}
+
+// ingestMedia creates a "contents" record for each attachment and resolved link and returns their IDs.
+// Sets are created by the caller beforehand, metadata.SetId links every record to it.
+func ingestMedia(metadata Metadata, attachments []*discordgo.MessageAttachment, resolvedMedia []ResolvedMedia) []string {
+	if !ingests.Start() {
+		slog.Warn("SHUTTING DOWN, MEDIA NOT INGESTED", "message", metadata.DiscordRef.MessageID)
+		return nil
+	}
+	defer ingests.Done()
+
+	var recordIDs []string
+
+	// 1) handle Discord attachments
+	// filetype is detected from the downloaded bytes, not Discord's ContentType header
+	for _, attach := range attachments {
+		id, err := processMediaLinks(attach.URL, attach.Filename, metadata)
 		if err != nil {
 			slog.Warn("unable to process media link (discord attach)", "MSG", err)
 			continue
 		}
+		recordIDs = append(recordIDs, id)
 	}
 
-	// 2) handle Imgur links
-	for _, imgurLink := range imgurLinks {
-		metadata.Filetype = "video"
-		filename := path.Base(imgurLink)
-		metadata.Mirror = imgurLink
+	// 2) handle links resolved from imgur, pixeldrain, catbox, etc.
+	for _, media := range resolvedMedia {
+		itemMetadata := metadata
+		media.applyTo(&itemMetadata)
 
-		_, err := processMediaLinks(imgurLink, filename, metadata)
+		id, err := processMediaLinks(media.URL, media.Filename, itemMetadata)
+		if err != nil && media.FallbackURL != "" {
+			slog.Info("RETRYING MEDIA LINK WITH ITS FALLBACK", "LINK", media.URL, "MSG", err)
+			itemMetadata.Mirror = media.FallbackURL
+			id, err = processMediaLinks(media.FallbackURL, path.Base(media.FallbackURL), itemMetadata)
+		}
 		if err != nil {
-			slog.Warn("unable to process media link (imgur)", "MSG", err)
+			slog.Warn("unable to process media link", "MSG", err)
 			continue
 		}
+		recordIDs = append(recordIDs, id)
 	}
+
+	return recordIDs
 }
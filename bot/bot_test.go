@@ -0,0 +1,70 @@
+package bot
+
+import (
+	"os"
+	"testing"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+)
+
+// newTestApp sets App to an empty Pocketbase with the collections of pb_schema.json,
+// restoring the previous App when the test ends
+func newTestApp(t *testing.T) *tests.TestApp {
+	t.Helper()
+
+	app, err := tests.NewTestApp(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(app.Cleanup)
+
+	schema, err := os.ReadFile("../pb_schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.ImportCollectionsByMarshaledJSON(schema, false); err != nil {
+		t.Fatal(err)
+	}
+
+	previous := App
+	App = &pocketbase.PocketBase{App: app}
+	t.Cleanup(func() { App = previous })
+	return app
+}
+
+// createTestRecord saves a record without validation, so fixtures only set the fields a test is about
+func createTestRecord(t *testing.T, app core.App, collection string, fields map[string]any) *core.Record {
+	t.Helper()
+
+	c, err := app.FindCollectionByNameOrId(collection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := core.NewRecord(c)
+	for key, value := range fields {
+		record.Set(key, value)
+	}
+	if err := app.SaveNoValidate(record); err != nil {
+		t.Fatal(err)
+	}
+	return record
+}
+
+// loadTestMappings loads the mappings from the test database, emptying them when the test ends
+func loadTestMappings(t *testing.T) {
+	t.Helper()
+
+	if err := initializeMappings(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		idolMap.replace(nil)
+		groupMap.replace(nil)
+		groupNames.replace(nil)
+		uploaderMap.replace(nil)
+		tagMap.replace(nil)
+		tagNames.replace(nil)
+	})
+}
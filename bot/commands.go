@@ -1,31 +1,17 @@
 package bot
 
 import (
+	"database/sql"
 	"encoding/json"
-	"fmt"
-	"io"
+	"errors"
 	"kcat-v3-be/bot/utils"
 	"log"
-	"net/http"
-	"net/url"
 	"strings"
-	"time"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/pocketbase/pocketbase/core"
 )
 
-var registeredCommands = make([]*discordgo.ApplicationCommand, 0)
-
-type PaginationState struct {
-	Pages     []string
-	Page      int
-	CreatedAt time.Time
-}
-
-// paginationStates stores user-specific pagination state using keys of format "userID:messageID"
-// This ensures each user has their own pagination state and cannot interfere with others
-var paginationStates = make(map[string]PaginationState)
-
 // getUserID safely extracts the user ID from an interaction
 // Handles both guild interactions (via Member) and DM interactions (via User)
 func getUserID(i *discordgo.InteractionCreate) string {
@@ -38,14 +24,14 @@ func getUserID(i *discordgo.InteractionCreate) string {
 	return ""
 }
 
-// getPaginationKey creates a user-specific pagination key
-// Format: "userID:messageID" to ensure each user has isolated pagination state
-func getPaginationKey(userID, messageID string) string {
-	return fmt.Sprintf("%s:%s", userID, messageID)
+// registerSlashCommands registers the slash commands in the scopes of DISCORD_COMMAND_GUILDS
+func registerSlashCommands(s *discordgo.Session) error {
+	return syncCommands(s, commandDefinitions())
 }
 
-func registerSlashCommands(s *discordgo.Session) {
-	commands := []*discordgo.ApplicationCommand{
+// commandDefinitions returns every slash command of the bot
+func commandDefinitions() []*discordgo.ApplicationCommand {
+	return []*discordgo.ApplicationCommand{
 		{
 			Name:        "revive",
 			Description: "Retrieve file based on a imgur link.",
@@ -86,6 +72,12 @@ func registerSlashCommands(s *discordgo.Session) {
 					Type:        discordgo.ApplicationCommandOptionBoolean,
 					Required:    false,
 				},
+				{
+					Name:        "shared",
+					Description: "Let anyone change pages, not only you.",
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Required:    false,
+				},
 			},
 		},
 		{
@@ -100,29 +92,13 @@ func registerSlashCommands(s *discordgo.Session) {
 				},
 			},
 		},
-	}
-
-	// --- GUILD REGISTRATION ---
-	// List every guild that should get the commands.
-	// Add or remove IDs as you like.
-	guildIDs := []string{
-		"1169291742504300595",
-		"1298381481739161690",
-	}
-
-	for _, gid := range guildIDs {
-		for _, cmd := range commands {
-			createdCmd, err := s.ApplicationCommandCreate(
-				s.State.User.ID,
-				gid, // register in this guild
-				cmd,
-			)
-			if err != nil {
-				log.Fatalf("Cannot create slash command %q in guild %s: %v",
-					cmd.Name, gid, err)
-			}
-			registeredCommands = append(registeredCommands, createdCmd)
-		}
+		searchCommand,
+		uploadCommand,
+		randomCommand,
+		statsCommand,
+		leaderboardCommand,
+		adminCommand,
+		myUploadsCommand,
 	}
 }
 
@@ -130,35 +106,116 @@ func registerSlashCommands(s *discordgo.Session) {
 func commandUsed(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	switch i.Type {
 	case discordgo.InteractionApplicationCommand:
-		switch i.ApplicationCommandData().Name {
+		name := i.ApplicationCommandData().Name
+		if !commandAllowed(s, i, name) {
+			return
+		}
+		if ok, msg := allowCommand(getUserID(i), i.GuildID, name); !ok {
+			respondEphemeral(s, i.Interaction, msg)
+			return
+		}
+
+		switch name {
 		case "revive":
 			handleReviveCommand(s, i)
 		case "unwrap":
 			handleUnwrapCommand(s, i)
 		case "source":
 			handleSourceCommand(s, i)
+		case "search":
+			handleSearchCommand(s, i)
+		case "upload":
+			handleUploadCommand(s, i)
+		case "random":
+			handleRandomCommand(s, i)
+		case "stats":
+			handleStatsCommand(s, i)
+		case "leaderboard":
+			handleLeaderboardCommand(s, i)
+		case "admin":
+			handleAdminCommand(s, i)
+		case "myuploads":
+			handleMyUploadsCommand(s, i)
 		}
+	case discordgo.InteractionApplicationCommandAutocomplete:
+		handleAutocomplete(s, i)
 	case discordgo.InteractionMessageComponent:
-		switch i.MessageComponentData().CustomID {
-		case "first", "prev", "next", "last":
+		customID := i.MessageComponentData().CustomID
+		if !commandAllowed(s, i, owningCommand(i, customID)) {
+			return
+		}
+		switch {
+		// the bare IDs are from before the controls were namespaced, they get disabled as expired
+		case strings.HasPrefix(customID, paginationPrefix),
+			customID == "first", customID == "prev", customID == "next", customID == "last":
 			handlePaginationInteraction(s, i)
+		case strings.HasPrefix(customID, randomRerollPrefix):
+			handleRandomReroll(s, i)
+		case strings.HasPrefix(customID, myUploadsPrefix):
+			handleMyUploadsComponent(s, i)
+		case strings.HasPrefix(customID, uploadModalPrefix):
+			handleUploadRetry(s, i)
+		}
+	case discordgo.InteractionModalSubmit:
+		customID := i.ModalSubmitData().CustomID
+		if !commandAllowed(s, i, owningCommand(i, customID)) {
+			return
+		}
+		switch {
+		case strings.HasPrefix(customID, uploadModalPrefix):
+			handleUploadModal(s, i)
+		case strings.HasPrefix(customID, myUploadsPrefix):
+			handleMyUploadsModal(s, i)
 		}
 	}
 }
 
-func handleReviveCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
-	// Extract the mirror link from the slash command option
-	mirrorLink := i.ApplicationCommandData().Options[0].StringValue()
+// commandAllowed checks the guild permissions of a command, telling the user when they are denied
+func commandAllowed(s *discordgo.Session, i *discordgo.InteractionCreate, name string) bool {
+	if name == "" {
+		return true
+	}
 
-	// Convert links from imgur.com to i.imgur.com + .mp4
-	if strings.HasPrefix(mirrorLink, "https://imgur.com/") {
-		mirrorLink = strings.Replace(mirrorLink, "https://imgur.com/", "https://i.imgur.com/", 1)
-		mirrorLink += ".mp4"
+	denial, err := commandDenial(i, name)
+	if err != nil {
+		log.Printf("permissions: unable to check /%s: %v", name, err)
+		respondEphemeral(s, i.Interaction, "Could not check your permissions, try again later.")
+		return false
+	}
+	if denial != "" {
+		respondEphemeral(s, i.Interaction, denial)
+		return false
 	}
+	return true
+}
+
+// owningCommand returns the command a component or modal belongs to, so members denied a command
+// can't keep using the controls of its earlier responses. Pagination controls are shared by several
+// commands and belong to the command that sent their message.
+func owningCommand(i *discordgo.InteractionCreate, customID string) string {
+	switch {
+	case strings.HasPrefix(customID, randomRerollPrefix):
+		return "random"
+	case strings.HasPrefix(customID, myUploadsPrefix):
+		return "myuploads"
+	case strings.HasPrefix(customID, uploadModalPrefix):
+		return "upload"
+	}
+
+	if i.Message != nil && i.Message.Interaction != nil {
+		// subcommands are named like "admin role map"
+		name, _, _ := strings.Cut(i.Message.Interaction.Name, " ")
+		return name
+	}
+	return ""
+}
+
+func handleReviveCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	// Extract the mirror link from the slash command option
+	mirrorLink := normalizeMirrorLink(i.ApplicationCommandData().Options[0].StringValue())
 
 	// Use internal Pocketbase API instead of HTTP
-	filter := fmt.Sprintf("mirror='%s'", strings.ReplaceAll(mirrorLink, "'", "\\'"))
-	records, err := App.FindRecordsByFilter("v1", filter, "", 1, 0)
+	records, err := newContentsQuery().mirror(mirrorLink).find("", 1)
 
 	if err != nil {
 		respondWithError(s, i.Interaction, "Could not query database.")
@@ -179,29 +236,20 @@ func handleReviveCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	}
 
 	record := records[0]
-	recordID := record.Id
-	fileValue := record.GetString("file")
-	kpfhdFileValue := record.GetString("kpfhdFile")
-
-	// Decide which URL or link to respond with
-	var content string
-	if fileValue != "" {
-		// Build your final URL: https://kcat.pics/v1/{id}/{file}
-		originalURL := fmt.Sprintf("https://kcat.pics/v1/%s/%s", recordID, fileValue)
-		content = fmt.Sprintf("Found copy in KpopCat: %s", originalURL)
-	} else if kpfhdFileValue != "" {
-		// If 'file' is empty but 'kpfhdFile' is present, just respond with that link
-		content = fmt.Sprintf("Found copy in KpopCat: %s", kpfhdFileValue)
-	} else {
-		// Both are empty?
-		content = "No 'file' or 'kpfhdFile' was available for that record."
+
+	if record.GetString("file") == "" && record.GetString("kpfhdFile") == "" {
+		respondWithError(s, i.Interaction, "No 'file' or 'kpfhdFile' was available for that record.")
+		return
 	}
 
-	// Respond with the final link
+	// Respond with the stored copy, its metadata and a link to the site
+	content, embeds, components := contentResponse(record)
 	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
 		Data: &discordgo.InteractionResponseData{
-			Content: content,
+			Content:    content,
+			Embeds:     embeds,
+			Components: components,
 		},
 	})
 	if err != nil {
@@ -209,9 +257,19 @@ func handleReviveCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	}
 }
 
+// normalizeMirrorLink converts links from imgur.com to i.imgur.com + .mp4, the form mirrors are stored in
+func normalizeMirrorLink(mirrorLink string) string {
+	if strings.HasPrefix(mirrorLink, "https://imgur.com/") {
+		mirrorLink = strings.Replace(mirrorLink, "https://imgur.com/", "https://i.imgur.com/", 1)
+		mirrorLink += ".mp4"
+	}
+	return mirrorLink
+}
+
 func handleUnwrapCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	var (
 		raw          bool
+		shared       bool
 		perPage      int64 = 1 // default
 		showMetadata bool      // default hidden unless explicitly requested
 	)
@@ -225,6 +283,8 @@ func handleUnwrapCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
 			raw = opt.BoolValue()
 		case "perpage":
 			perPage = opt.IntValue()
+		case "shared":
+			shared = opt.BoolValue()
 		case "hide_metadata":
 			// Although the option is named "hide_metadata", per requirement we use it to SHOW metadata
 			// on the first page when provided/true. By default metadata is hidden.
@@ -242,228 +302,143 @@ func handleUnwrapCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	}
 	linkID := parts[len(parts)-1] // last path segment
 
-	var filterStr string
-	switch {
-	case strings.Contains(setLink, "/set/"):
-		// strict match on the single‑value "set" field
-		filterStr = fmt.Sprintf("(set=\"%s\")", linkID)
-	case strings.Contains(setLink, "/collection/"):
-		// "collections" is an array → use ~ to match if ID is present
-		filterStr = fmt.Sprintf("(collections~\"%s\")", linkID)
-	default:
+	if _, _, err := unwrapQuery(setLink, linkID); err != nil {
 		respondWithError(s, i.Interaction,
 			"Link must contain either /set/ or /collection/ in the path.")
 		return
 	}
 
-	// 2. Build the request URL (using HTTP for now, but could be refactored to use internal API)
-	baseURL := "https://kcat.pockethost.io/api/collections/contents/records"
-	q := url.Values{}
-	q.Set("page", "1")
-	q.Set("perPage", "12")    // We'll still fetch up to 12 items
-	q.Set("sort", "-created") // sorted by newest first
-	q.Set("filter", filterStr)
-	// expand fields to get group, idol, uploader, etc.
-	q.Set("expand", "idol,group,tag,uploader,likes")
-
-	fullURL := fmt.Sprintf("%s?%s", baseURL, q.Encode())
-
-	resp, err := http.Get(fullURL)
-	if err != nil {
-		respondWithError(s, i.Interaction, "Could not contact PocketBase.")
-		return
+	// 2. Metadata embed of the set or collection, it needs every item for the idols, groups and tags
+	var embeds []*discordgo.MessageEmbed
+	if showMetadata {
+		unwrapped, err := findUnwrapItems(setLink, linkID)
+		if err != nil {
+			log.Printf("unwrap: query failed: %v", err)
+			respondWithError(s, i.Interaction, "Could not query database.")
+			return
+		}
+		if unwrapped.Set != nil {
+			expandForEmbed(unwrapped.Items...)
+			embeds = append(embeds, setEmbed(unwrapped.Set, unwrapped.Items))
+		}
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		respondWithError(s, i.Interaction,
-			fmt.Sprintf("Received non-OK status from PocketBase: %d", resp.StatusCode))
-		return
+	// clamp perPage between 1 and 5
+	if perPage < 1 {
+		perPage = 1
 	}
-
-	// 3. Parse JSON response
-	// We'll define a struct that includes extra fields we want to display.
-	var pbResp struct {
-		Items []struct {
-			ID        string `json:"id"`
-			File      string `json:"file"`
-			KpfhdFile string `json:"kpfhdFile"`
-			Mirror    string `json:"mirror"`
-			Title     string `json:"title"`
-			Created   string `json:"created"`
-			Expand    struct {
-				Group []struct {
-					Name string `json:"name"`
-				} `json:"group"`
-				Idol []struct {
-					Name string `json:"name"`
-				} `json:"idol"`
-				Uploader []struct {
-					Name string `json:"name"`
-				} `json:"uploader"`
-			} `json:"expand"`
-		} `json:"items"`
+	if perPage > 5 {
+		perPage = 5
 	}
 
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		respondWithError(s, i.Interaction, "Failed to read PocketBase response.")
-		return
-	}
-	err = json.Unmarshal(bodyBytes, &pbResp)
+	// 3. Pages are loaded as they are viewed
+	state, err := newLazyPagination("unwrap", unwrapPageParams{
+		Link:    setLink,
+		LinkID:  linkID,
+		Raw:     raw,
+		PerPage: int(perPage),
+	})
 	if err != nil {
-		respondWithError(s, i.Interaction, "Failed to parse JSON from PocketBase.")
-		return
-	}
-
-	if len(pbResp.Items) == 0 {
-		respondWithError(s, i.Interaction, "No items found for that set.")
+		log.Printf("unwrap: unable to create paginator: %v", err)
+		respondWithError(s, i.Interaction, "Could not query database.")
 		return
 	}
+	state.Embeds = embeds
+	state.Shared = shared
 
-	// --- metadata header (same as /unwrap) ---
-	firstItem := pbResp.Items[0]
-
-	// Collect group, idol, and uploader names
-	var groupNames, idolNames, uploaderNames []string
-	for _, g := range firstItem.Expand.Group {
-		groupNames = append(groupNames, g.Name)
-	}
-	for _, d := range firstItem.Expand.Idol {
-		idolNames = append(idolNames, d.Name)
-	}
-	for _, u := range firstItem.Expand.Uploader {
-		uploaderNames = append(uploaderNames, u.Name)
-	}
-
-	groupLine := strings.Join(groupNames, ", ")
-	idolLine := strings.Join(idolNames, ", ")
-	uploaderLine := strings.Join(uploaderNames, ", ")
-
-	metaHeader := fmt.Sprintf(
-		"**Title**: %s\n**Created**: %s\n**Groups**: %s\n**Idols**: %s\n**Uploader**: %s\n\n**Links**:",
-		firstItem.Title,
-		firstItem.Created,
-		groupLine,
-		idolLine,
-		uploaderLine,
-	)
-
-	var links []string
-	for _, item := range pbResp.Items {
-		link := item.Mirror
-		if raw || item.Mirror == "" {
-			link = item.KpfhdFile
-			if link == "" {
-				link = utils.GenerateLinkFromFilename(item.ID, item.File)
-			}
-		}
-		if link != "" {
-			links = append(links, link)
+	if err := sendPaginatedResponse(s, i, state); err != nil {
+		if errors.Is(err, errNoPages) {
+			respondWithError(s, i.Interaction, "No items found for that set.")
+			return
 		}
+		log.Printf("unwrap: query failed: %v", err)
+		respondWithError(s, i.Interaction, "Could not query database.")
 	}
+}
 
-	fmt.Printf("Found %d links\n", len(links))
+// unwrapPageParams are what an /unwrap paginator loads its pages from
+type unwrapPageParams struct {
+	Link    string `json:"link"`
+	LinkID  string `json:"linkId"`
+	Raw     bool   `json:"raw"`
+	PerPage int    `json:"perPage"`
+}
 
-	if len(links) == 0 {
-		respondWithError(s, i.Interaction, "No usable links found.")
-		return
+// loadUnwrapPage renders a page of the links of a set or collection, newest first
+func loadUnwrapPage(raw json.RawMessage, page int) (string, int, error) {
+	var params unwrapPageParams
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return "", 0, err
 	}
 
-	// clamp perPage between 1 and 5
-	if perPage < 1 {
-		perPage = 1
-	}
-	if perPage > 5 {
-		perPage = 5
+	q, _, err := unwrapQuery(params.Link, params.LinkID)
+	if err != nil {
+		return "", 0, err
 	}
 
-	var pages []string
-	for idx := 0; idx < len(links); idx += int(perPage) {
-		end := idx + int(perPage)
-		if end > len(links) {
-			end = len(links)
-		}
-		chunk := strings.Join(links[idx:end], "\n")
-		if idx == 0 && showMetadata {
-			chunk = metaHeader + "\n" + chunk
+	return contentsPage(q, params.PerPage, page, "\n", func(item *core.Record) string {
+		link := item.GetString("mirror")
+		if params.Raw || link == "" {
+			link = item.GetString("kpfhdFile")
+			if link == "" && item.GetString("file") != "" {
+				link = utils.GenerateLinkFromFilename(item.Id, item.GetString("file"))
+			}
 		}
-		pages = append(pages, chunk)
-	}
-
-	// Save to some temporary store if needed (e.g., in-memory map[string][]string keyed by Interaction ID)
-	// For now just send first page:
-	// Acknowledge the interaction first (defer full response)
-	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		return link
 	})
+}
+
+// errUnwrapLink is returned for /unwrap links that are neither a set nor a collection
+var errUnwrapLink = errors.New("link must contain either /set/ or /collection/")
 
-	sendPaginatedResponse(s, i, pages, 0)
+// unwrappedSet is a set or collection with its items
+type unwrappedSet struct {
+	// Set is the "contents_sets" or "contents_collections" record, nil when it no longer exists
+	Set   *core.Record
+	Items []*core.Record
 }
 
-func buildPaginationContent(pages []string, page int) (string, []discordgo.MessageComponent) {
-	maxPage := len(pages) - 1
-	content := fmt.Sprintf("**Page %d / %d**\n\n%s", page+1, maxPage+1, pages[page])
-
-	components := []discordgo.MessageComponent{
-		discordgo.ActionsRow{
-			Components: []discordgo.MessageComponent{
-				discordgo.Button{CustomID: "first", Emoji: &discordgo.ComponentEmoji{Name: "⏮️"}, Style: discordgo.PrimaryButton},
-				discordgo.Button{CustomID: "prev", Emoji: &discordgo.ComponentEmoji{Name: "⬅️"}, Style: discordgo.PrimaryButton},
-				discordgo.Button{CustomID: "next", Emoji: &discordgo.ComponentEmoji{Name: "➡️"}, Style: discordgo.PrimaryButton},
-				discordgo.Button{CustomID: "last", Emoji: &discordgo.ComponentEmoji{Name: "⏭️"}, Style: discordgo.PrimaryButton},
-			},
-		},
+// unwrapQuery returns the query for the items behind an /unwrap link,
+// along with the collection of the set or collection it points to
+func unwrapQuery(link, linkID string) (*contentsQuery, string, error) {
+	q := newContentsQuery()
+	switch {
+	case strings.Contains(link, "/set/"):
+		// strict match on the single‑value "set" field
+		return q.inSet(linkID), "contents_sets", nil
+	case strings.Contains(link, "/collection/"):
+		// "collections" is an array → match if any of its IDs is the collection
+		return q.inCollection(linkID), "contents_collections", nil
+	default:
+		return nil, "", errUnwrapLink
 	}
-
-	return content, components
 }
 
-func sendPaginatedResponse(s *discordgo.Session, ic *discordgo.InteractionCreate, pages []string, page int) {
-	content, components := buildPaginationContent(pages, page)
+// findUnwrapItems returns the set or collection behind an /unwrap link with all of its items, newest first
+func findUnwrapItems(link, linkID string) (unwrappedSet, error) {
+	var result unwrappedSet
 
-	// Edit the original deferred reply (safer than follow‑up for first page)
-	msg, err := s.InteractionResponseEdit(
-		ic.Interaction,
-		&discordgo.WebhookEdit{
-			Content:    &content,
-			Components: &components,
-		},
-	)
+	q, collection, err := unwrapQuery(link, linkID)
 	if err != nil {
-		log.Printf("pagination: initial edit failed: %v", err)
-		return
+		return result, err
 	}
 
-	// Store state by user ID + message ID for user-specific pagination
-	// This prevents users from interfering with each other's pagination state
-	userID := getUserID(ic)
-	if userID != "" {
-		key := getPaginationKey(userID, msg.ID)
-		paginationStates[key] = PaginationState{
-			Pages:     pages,
-			Page:      page,
-			CreatedAt: time.Now(),
-		}
-
-		// Clean up old pagination states (older than 1 hour) to prevent memory leaks
-		cleanupOldPaginationStates()
+	set, err := App.FindRecordById(collection, linkID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return result, err
 	}
+	result.Set = set
+
+	result.Items, err = q.find("-created", 0)
+	return result, err
 }
 
 func handleSourceCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	// Extract the mirror link from the slash command option
-	mirrorLink := i.ApplicationCommandData().Options[0].StringValue()
-
-	// Convert links from imgur.com to i.imgur.com + .mp4
-	if strings.HasPrefix(mirrorLink, "https://imgur.com/") {
-		mirrorLink = strings.Replace(mirrorLink, "https://imgur.com/", "https://i.imgur.com/", 1)
-		mirrorLink += ".mp4"
-	}
+	mirrorLink := normalizeMirrorLink(i.ApplicationCommandData().Options[0].StringValue())
 
 	// Use internal Pocketbase API instead of HTTP
-	filter := fmt.Sprintf("mirror='%s'", strings.ReplaceAll(mirrorLink, "'", "\\'"))
-	records, err := App.FindRecordsByFilter("v1", filter, "", 1, 0)
+	records, err := newContentsQuery().mirror(mirrorLink).find("", 1)
 
 	if err != nil {
 		respondWithError(s, i.Interaction, "Could not query database.")
@@ -479,19 +454,19 @@ func handleSourceCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	record := records[0]
 	sourceValue := record.GetString("source")
 
-	// Decide which URL or link to respond with
-	var content string
-	if sourceValue != "" {
-		content = fmt.Sprintf("🔗 Found source in KpopCat: %s", sourceValue)
-	} else {
-		content = "No source was available for that gif."
+	if sourceValue == "" {
+		respondWithError(s, i.Interaction, "No source was available for that gif.")
+		return
 	}
 
-	// Respond with the final link
+	// The source goes in the content so Discord unfurls the video, the embed describes the gif
+	_, embeds, components := contentResponse(record)
 	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
 		Data: &discordgo.InteractionResponseData{
-			Content: content,
+			Content:    sourceValue,
+			Embeds:     embeds,
+			Components: components,
 		},
 	})
 	if err != nil {
@@ -499,80 +474,23 @@ func handleSourceCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	}
 }
 
-func handlePaginationInteraction(s *discordgo.Session, i *discordgo.InteractionCreate) {
-	userID := getUserID(i)
-	if userID == "" {
-		respondWithError(s, i.Interaction, "Could not identify user.")
-		return
-	}
-
-	messageID := i.Message.ID
-	key := getPaginationKey(userID, messageID)
-
-	state, ok := paginationStates[key]
-	if !ok {
-		respondWithError(s, i.Interaction, "Pagination state not found for this user.")
-		return
-	}
-
-	switch i.MessageComponentData().CustomID {
-	case "first":
-		state.Page = 0
-	case "prev":
-		if state.Page > 0 {
-			state.Page--
-		}
-	case "next":
-		if state.Page < len(state.Pages)-1 {
-			state.Page++
-		}
-	case "last":
-		state.Page = len(state.Pages) - 1
-	}
-
-	paginationStates[key] = state
-
-	content, components := buildPaginationContent(state.Pages, state.Page)
-
-	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-		Type: discordgo.InteractionResponseUpdateMessage,
+// respondWithError is a helper function to unify error responses
+func respondWithError(s *discordgo.Session, i *discordgo.Interaction, msg string) {
+	_ = s.InteractionRespond(i, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
 		Data: &discordgo.InteractionResponseData{
-			Content:    content,
-			Components: components,
+			Content: msg,
 		},
 	})
-	if err != nil {
-		log.Printf("Error updating pagination message: %v", err)
-	}
 }
 
-// cleanupOldPaginationStates removes pagination states older than 1 hour to prevent memory leaks
-// This is called after each new pagination state is created to maintain reasonable memory usage
-func cleanupOldPaginationStates() {
-	cutoff := time.Now().Add(-1 * time.Hour)
-	for key, state := range paginationStates {
-		if state.CreatedAt.Before(cutoff) {
-			delete(paginationStates, key)
-		}
-	}
-}
-
-// respondWithError is a helper function to unify error responses
-func respondWithError(s *discordgo.Session, i *discordgo.Interaction, msg string) {
+// respondEphemeral replies with a message only the user who used the command can see
+func respondEphemeral(s *discordgo.Session, i *discordgo.Interaction, msg string) {
 	_ = s.InteractionRespond(i, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
 		Data: &discordgo.InteractionResponseData{
 			Content: msg,
+			Flags:   discordgo.MessageFlagsEphemeral,
 		},
 	})
 }
-
-// RemoveCommands can be called from main.go to remove slash commands on shutdown.
-func RemoveCommands(s *discordgo.Session) {
-	for _, cmd := range registeredCommands {
-		err := s.ApplicationCommandDelete(s.State.User.ID, "", cmd.ID)
-		if err != nil {
-			log.Printf("Cannot delete command %q: %v", cmd.Name, err)
-		}
-	}
-}
@@ -0,0 +1,100 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestUnwrapQueries(t *testing.T) {
+	app := newTestApp(t)
+
+	set := createTestRecord(t, app, "contents_sets", map[string]any{"title": "set"})
+	otherSet := createTestRecord(t, app, "contents_sets", map[string]any{"title": "other"})
+	collection := createTestRecord(t, app, "contents_collections", map[string]any{"name": "collection"})
+
+	first := createTestRecord(t, app, "contents", map[string]any{"title": "first", "set": set.Id, "mirror": "https://i.imgur.com/first.mp4", "collections": []string{collection.Id}})
+	second := createTestRecord(t, app, "contents", map[string]any{"title": "second", "set": set.Id, "kpfhdFile": "https://kpfhd.example/second.mp4"})
+	createTestRecord(t, app, "contents", map[string]any{"title": "deleted", "set": set.Id, "mirror": "https://i.imgur.com/deleted.mp4", "deleted": "2024-01-01 00:00:00.000Z"})
+	other := createTestRecord(t, app, "contents", map[string]any{"title": "other", "set": otherSet.Id, "mirror": "https://i.imgur.com/other.mp4", "collections": []string{"elsewhere", collection.Id}})
+
+	tests := []struct {
+		name    string
+		link    string
+		linkID  string
+		set     string
+		items   []string
+		entries []string
+	}{
+		{
+			name:    "set",
+			link:    "https://kpop.cat/set/" + set.Id,
+			linkID:  set.Id,
+			set:     set.Id,
+			items:   []string{first.Id, second.Id},
+			entries: []string{"https://i.imgur.com/first.mp4", "https://kpfhd.example/second.mp4"},
+		},
+		{
+			name:    "collection",
+			link:    "https://kpop.cat/collection/" + collection.Id,
+			linkID:  collection.Id,
+			set:     collection.Id,
+			items:   []string{first.Id, other.Id},
+			entries: []string{"https://i.imgur.com/first.mp4", "https://i.imgur.com/other.mp4"},
+		},
+		{
+			name:   "set that no longer exists",
+			link:   "https://kpop.cat/set/missing",
+			linkID: "missing",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unwrapped, err := findUnwrapItems(tt.link, tt.linkID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.set == "" && unwrapped.Set != nil {
+				t.Errorf("set = %s, want none", unwrapped.Set.Id)
+			}
+			if tt.set != "" && (unwrapped.Set == nil || unwrapped.Set.Id != tt.set) {
+				t.Errorf("set = %v, want %s", unwrapped.Set, tt.set)
+			}
+
+			var items []string
+			for _, item := range unwrapped.Items {
+				items = append(items, item.Id)
+			}
+			slices.Sort(items)
+			want := slices.Sorted(slices.Values(tt.items))
+			if !slices.Equal(items, want) {
+				t.Errorf("items = %v, want %v", items, want)
+			}
+
+			// one item per page, the pages hold the same links findUnwrapItems found
+			params, _ := json.Marshal(unwrapPageParams{Link: tt.link, LinkID: tt.linkID, PerPage: 1})
+			var entries []string
+			for page := 0; page < len(tt.entries); page++ {
+				content, pageCount, err := loadUnwrapPage(params, page)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if pageCount != len(tt.entries) {
+					t.Errorf("page count = %d, want %d", pageCount, len(tt.entries))
+				}
+				entries = append(entries, strings.Split(content, "\n")...)
+			}
+			slices.Sort(entries)
+			if !slices.Equal(entries, tt.entries) {
+				t.Errorf("entries = %v, want %v", entries, tt.entries)
+			}
+		})
+	}
+
+	if _, err := findUnwrapItems("https://kpop.cat/watch/"+first.Id, first.Id); !errors.Is(err, errUnwrapLink) {
+		t.Errorf("error = %v, want %v", err, errUnwrapLink)
+	}
+}
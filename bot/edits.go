@@ -0,0 +1,218 @@
+package bot
+
+import (
+	"log/slog"
+
+	"kcat-v3-be/bot/utils"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// editableFields are the "contents" fields an uploader can change by editing their post
+var editableFields = []string{"title", "idol", "group", "tag", "date", "source", "hqMirror"}
+
+// optionalEditableFields are the editable fields an edit only changes when it sets them
+var optionalEditableFields = map[string]bool{"source": true, "hqMirror": true}
+
+// messageUpdate re-parses the metadata of an edited upload post and updates the records created from it
+func messageUpdate(s *discordgo.Session, m *discordgo.MessageUpdate) {
+	if m == nil || m.Message == nil || m.Author == nil {
+		return
+	}
+	if m.Author.Bot {
+		return
+	}
+	// Discord also sends updates when it unfurls link embeds, only handle actual edits
+	if m.EditedTimestamp == nil {
+		return
+	}
+
+	records, err := findRecordsByDiscordMessage(m.ID)
+	if err != nil {
+		slog.Error("UNABLE TO FIND RECORDS FOR EDITED MESSAGE", "MSG", err)
+		return
+	}
+	if len(records) == 0 {
+		return
+	}
+
+	// same rules as messageCreate, replies only reuse the metadata of the original post
+	metadata := Metadata{}
+	if utils.BotIsMentioned(s, &discordgo.MessageCreate{Message: m.Message}) {
+		// metadata comes from the message content only
+	} else if len(m.MentionRoles) > 0 && allowedChannelIDs[m.ChannelID] {
+		idolGroups := idolGroupsFromRoles(s, m.GuildID, m.MentionRoles)
+		if len(idolGroups) < 1 {
+			slog.Warn("PING ROLES NOT FOUND FOR EDITED MESSAGE", "MESSAGE", m.ID)
+			return
+		}
+		metadata = createMetadata(idolGroups)
+	} else {
+		return
+	}
+
+	if err := extractMetadata(m.Content, &metadata); err != nil {
+		slog.Warn("EDITED MESSAGE HAS NO VALID METADATA, KEEPING RECORDS", "MESSAGE", m.ID, "MSG", err)
+		return
+	}
+	editable := editedFields(metadata, m.BeforeUpdate)
+
+	setIDs := make(map[string]bool)
+	for _, record := range records {
+		applyMetadataMap(record, editable)
+		if err := App.Save(record); err != nil {
+			slog.Error("ERROR SAVING RECORD", "MSG", err)
+			continue
+		}
+		if setID := record.GetString("set"); setID != "" {
+			setIDs[setID] = true
+		}
+	}
+
+	for setID := range setIDs {
+		set, err := App.FindRecordById("contents_sets", setID)
+		if err != nil {
+			slog.Error("UNABLE TO FIND SET RECORD", "SET", setID, "MSG", err)
+			continue
+		}
+		fillSetRecord(set, metadata, set.GetDateTime("created").Time())
+		if err := App.Save(set); err != nil {
+			slog.Error("ERROR SAVING RECORD", "MSG", err)
+		}
+	}
+
+	slog.Info("✏️ Updated records from edited message", "MESSAGE", m.ID, "records", len(records), "sets", len(setIDs))
+}
+
+// editedFields returns the "contents" fields an edited post changes. Fields only some posts
+// have, like source or hqMirror, are kept unless the edit sets them, and a relative date like
+// "today" is kept unless the edit changed the date text, so it isn't moved to the time of the edit.
+// before is the post before the edit, nil when it wasn't cached.
+func editedFields(metadata Metadata, before *discordgo.Message) map[string]string {
+	// the uploader can't be edited, so it is not resolved and no uploader is ever created here
+	metadataMap := metadata.metadataFields()
+
+	editable := make(map[string]string, len(editableFields)+1)
+	for _, key := range editableFields {
+		if metadataMap[key] != "" || !optionalEditableFields[key] {
+			editable[key] = metadataMap[key]
+		}
+	}
+	// contenttype is detected on ingest, only override it when given explicitly
+	if metadata.ContentType != "" {
+		editable["contenttype"] = metadata.ContentType
+	}
+
+	if metadata.Date == "" || isRelativeDate(metadata.Date) {
+		delete(editable, "date")
+		if before != nil && metadata.Date != "" {
+			previous := Metadata{}
+			_ = extractMetadata(before.Content, &previous) // only the date text is needed
+			if previous.Date != metadata.Date {
+				editable["date"] = metadataMap["date"]
+			}
+		}
+	}
+
+	return editable
+}
+
+// isRelativeDate reports whether a date is resolved from the time it is parsed at
+func isRelativeDate(date string) bool {
+	return date == "now" || date == "today"
+}
+
+// messageDelete applies the guild delete policy to the records created from a deleted message
+func messageDelete(s *discordgo.Session, m *discordgo.MessageDelete) {
+	if m == nil || m.Message == nil {
+		return
+	}
+
+	handleDeletedMessage(m.GuildID, m.ID)
+}
+
+// messageDeleteBulk is the same as messageDelete for messages purged by moderators
+func messageDeleteBulk(s *discordgo.Session, m *discordgo.MessageDeleteBulk) {
+	if m == nil {
+		return
+	}
+
+	for _, messageID := range m.Messages {
+		handleDeletedMessage(m.GuildID, messageID)
+	}
+}
+
+// handleDeletedMessage soft deletes or flags for review every record of a deleted message.
+// Records are never removed here, a curator has the last word.
+func handleDeletedMessage(guildID, messageID string) {
+	config := loadGuildConfig(guildID)
+	if config.DeletePolicy == DeletePolicyIgnore {
+		return
+	}
+
+	records, err := findRecordsByDiscordMessage(messageID)
+	if err != nil {
+		slog.Error("UNABLE TO FIND RECORDS FOR DELETED MESSAGE", "MSG", err)
+		return
+	}
+	if len(records) == 0 {
+		return
+	}
+
+	setIDs := make(map[string]bool)
+	for _, record := range records {
+		switch config.DeletePolicy {
+		case DeletePolicySoftDelete:
+			record.Set("deleted", types.NowDateTime())
+		default:
+			record.Set("needsReview", true)
+		}
+
+		if err := App.Save(record); err != nil {
+			slog.Error("ERROR SAVING RECORD", "MSG", err)
+			continue
+		}
+		if setID := record.GetString("set"); setID != "" {
+			setIDs[setID] = true
+		}
+	}
+
+	if config.DeletePolicy == DeletePolicySoftDelete {
+		for setID := range setIDs {
+			softDeleteSetIfEmpty(setID)
+		}
+	}
+
+	slog.Info("🗑️ Applied delete policy to records of deleted message", "MESSAGE", messageID, "POLICY", config.DeletePolicy, "records", len(records))
+}
+
+// softDeleteSetIfEmpty soft deletes a set once all of its items are soft deleted
+func softDeleteSetIfEmpty(setID string) {
+	remaining, err := App.CountRecords("contents", dbx.HashExp{"set": setID, "deleted": ""})
+	if err != nil {
+		slog.Error("UNABLE TO COUNT SET ITEMS", "SET", setID, "MSG", err)
+		return
+	}
+	if remaining > 0 {
+		return
+	}
+
+	set, err := App.FindRecordById("contents_sets", setID)
+	if err != nil {
+		slog.Error("UNABLE TO FIND SET RECORD", "SET", setID, "MSG", err)
+		return
+	}
+
+	set.Set("deleted", types.NowDateTime())
+	if err := App.Save(set); err != nil {
+		slog.Error("ERROR SAVING RECORD", "MSG", err)
+	}
+}
+
+// findRecordsByDiscordMessage returns the "contents" records created from a Discord message
+func findRecordsByDiscordMessage(messageID string) ([]*core.Record, error) {
+	return App.FindRecordsByFilter("contents", "discordMessageId = {:messageId}", "", 0, 0, dbx.Params{"messageId": messageID})
+}
@@ -0,0 +1,40 @@
+package bot
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestEditedFieldsKeepsUnsetFields(t *testing.T) {
+	metadata := Metadata{Title: "stage", Date: "today"}
+	before := &discordgo.Message{Content: "title: stage\ndate: today\nsource: https://example.com"}
+
+	editable := editedFields(metadata, before)
+	for _, key := range []string{"source", "hqMirror", "date"} {
+		if value, ok := editable[key]; ok {
+			t.Errorf("%s = %q, want it kept", key, value)
+		}
+	}
+	if editable["title"] != "stage" {
+		t.Errorf("title = %q, want stage", editable["title"])
+	}
+}
+
+func TestEditedFieldsChangesSetFields(t *testing.T) {
+	metadata := Metadata{Date: "today", Source: "https://example.com/new"}
+	before := &discordgo.Message{Content: "date: 240101"}
+
+	editable := editedFields(metadata, before)
+	if editable["source"] != metadata.Source {
+		t.Errorf("source = %q, want %q", editable["source"], metadata.Source)
+	}
+	if editable["date"] == "" {
+		t.Error("date was kept, want the changed date")
+	}
+
+	// the edit time is unknown without the previous post, the stored date stays
+	if _, ok := editedFields(metadata, nil)["date"]; ok {
+		t.Error("date changed without the previous post")
+	}
+}
@@ -0,0 +1,209 @@
+package bot
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"kcat-v3-be/bot/utils"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// siteURL is the KpopCat website the embeds link to
+const siteURL = "https://kpopcat.pics"
+
+// embedColor is the accent color of the bot embeds
+const embedColor = 0xf4a7b9
+
+// previewThumb is the "file" thumb size used for image previews, see the field in pb_schema.json
+const previewThumb = "0x300"
+
+// embedExpands are the relations shown in the embeds
+var embedExpands = []string{"idol", "group", "tag", "uploader"}
+
+// expandForEmbed loads the relations shown in the embeds of the records
+func expandForEmbed(records ...*core.Record) {
+	if len(records) == 0 {
+		return
+	}
+	if errs := App.ExpandRecords(records, embedExpands, nil); len(errs) > 0 {
+		log.Printf("embed: unable to expand records: %v", errs)
+	}
+}
+
+// contentResponse renders a "contents" record as a message: the file link as content, so Discord
+// plays videos in its own unfurl, plus the metadata embed and a button to the site
+func contentResponse(record *core.Record) (string, []*discordgo.MessageEmbed, []discordgo.MessageComponent) {
+	expandForEmbed(record)
+	embeds := []*discordgo.MessageEmbed{contentEmbed(record)}
+
+	var components []discordgo.MessageComponent
+	if url := contentSiteURL(record); url != "" {
+		components = append(components, siteButton(url))
+	}
+
+	return contentFileLink(record), embeds, components
+}
+
+// contentEmbed renders a "contents" record, its relations must already be expanded
+func contentEmbed(record *core.Record) *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{
+		Title:  record.GetString("title"),
+		URL:    contentFileLink(record),
+		Color:  embedColor,
+		Fields: relationFields(record),
+	}
+
+	if date := record.GetDateTime("date"); !date.IsZero() {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "Date", Value: date.Time().Format("2006-01-02"), Inline: true})
+	}
+	if source := record.GetString("source"); source != "" {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "Source", Value: source})
+	}
+	if preview := contentPreviewURL(record); preview != "" {
+		embed.Image = &discordgo.MessageEmbedImage{URL: preview}
+	}
+
+	return embed
+}
+
+// setEmbed renders a "contents_sets" or "contents_collections" record with its items.
+// Idols, groups and uploaders are gathered from the items, their relations must already be expanded.
+func setEmbed(set *core.Record, items []*core.Record) *discordgo.MessageEmbed {
+	path := "set"
+	if set.Collection().Name == "contents_collections" {
+		path = "collection"
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title: set.GetString("title"),
+		URL:   fmt.Sprintf("%s/%s/%s", siteURL, path, set.Id),
+		Color: embedColor,
+	}
+
+	fields := []struct {
+		Label    string
+		Relation string
+		Inline   bool
+	}{
+		{"Idols", "idol", true},
+		{"Groups", "group", true},
+		{"Uploader", "uploader", true},
+		{"Tags", "tag", false},
+	}
+	for _, f := range fields {
+		var names []string
+		seen := make(map[string]bool)
+		for _, item := range items {
+			for _, related := range item.ExpandedAll(f.Relation) {
+				if !seen[related.Id] {
+					seen[related.Id] = true
+					names = append(names, related.GetString("name"))
+				}
+			}
+		}
+		if len(names) > 0 {
+			embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: f.Label, Value: truncateField(strings.Join(names, ", ")), Inline: f.Inline})
+		}
+	}
+
+	embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "Items", Value: fmt.Sprintf("%d", len(items)), Inline: true})
+
+	date := set.GetDateTime("date")
+	if date.IsZero() {
+		date = set.GetDateTime("created")
+	}
+	if !date.IsZero() {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "Date", Value: date.Time().Format("2006-01-02"), Inline: true})
+	}
+
+	if cover := set.GetString("cover"); cover != "" {
+		embed.Thumbnail = &discordgo.MessageEmbedThumbnail{URL: cover}
+	} else {
+		for _, item := range items {
+			if preview := contentPreviewURL(item); preview != "" {
+				embed.Thumbnail = &discordgo.MessageEmbedThumbnail{URL: preview}
+				break
+			}
+		}
+	}
+
+	return embed
+}
+
+// relationFields renders the idols, groups, uploader and tags of an expanded record
+func relationFields(record *core.Record) []*discordgo.MessageEmbedField {
+	fields := []*discordgo.MessageEmbedField{
+		{Name: "Idols", Value: expandedNames(record, "idol"), Inline: true},
+		{Name: "Groups", Value: expandedNames(record, "group"), Inline: true},
+		{Name: "Uploader", Value: expandedNames(record, "uploader"), Inline: true},
+	}
+	if tags := expandedNames(record, "tag"); tags != "-" {
+		fields = append(fields, &discordgo.MessageEmbedField{Name: "Tags", Value: tags})
+	}
+	return fields
+}
+
+// siteButton is a row with a link button to the KpopCat website
+func siteButton(url string) discordgo.ActionsRow {
+	return discordgo.ActionsRow{
+		Components: []discordgo.MessageComponent{
+			discordgo.Button{Label: "Open on KpopCat", Style: discordgo.LinkButton, URL: url},
+		},
+	}
+}
+
+// contentFileLink returns the stored file of a "contents" record, or its best other link without one
+func contentFileLink(record *core.Record) string {
+	if file := record.GetString("file"); file != "" {
+		return utils.GenerateLinkFromFilename(record.Id, file)
+	}
+	return recordLink(record)
+}
+
+// contentSiteURL returns the page of a "contents" record on the site, the set page for items of a set
+func contentSiteURL(record *core.Record) string {
+	if set := record.GetString("set"); set != "" {
+		return fmt.Sprintf("%s/set/%s", siteURL, set)
+	}
+	return contentFileLink(record)
+}
+
+// contentPreviewURL returns a thumbnail of image records and the animated webp of videos,
+// videos have no preview until the webp conversion is done
+func contentPreviewURL(record *core.Record) string {
+	switch record.GetString("filetype") {
+	case "image":
+		if file := record.GetString("file"); file != "" {
+			return utils.GenerateLinkFromFilename(record.Id, file) + "?thumb=" + previewThumb
+		}
+	case "video":
+		if webp := record.GetString("webp"); webp != "" {
+			return utils.GenerateLinkFromFilename(record.Id, webp)
+		}
+	}
+	return ""
+}
+
+// expandedNames joins the names of an expanded relation, "-" when there are none
+func expandedNames(record *core.Record, field string) string {
+	var names []string
+	for _, related := range record.ExpandedAll(field) {
+		names = append(names, related.GetString("name"))
+	}
+	if len(names) == 0 {
+		return "-"
+	}
+	return truncateField(strings.Join(names, ", "))
+}
+
+// truncateField cuts a value to the 1024 characters Discord allows in an embed field
+func truncateField(value string) string {
+	runes := []rune(value)
+	if len(runes) <= 1024 {
+		return value
+	}
+	return string(runes[:1020]) + " ..."
+}
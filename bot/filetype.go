@@ -0,0 +1,203 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+// FileInfo describes a downloaded file as detected from its bytes,
+// mapped onto the "filetype" and "contenttype" selects of "contents"
+type FileInfo struct {
+	MimeType    string
+	Extension   string
+	Filetype    string // "video" or "image"
+	ContentType string // "gif" or "pic"
+}
+
+// detectFileInfo sniffs the mimetype of the file and probes the container
+// to tell animated media apart from still images
+func detectFileInfo(data []byte) (FileInfo, error) {
+	mime := mimetype.Detect(data)
+	info := FileInfo{
+		MimeType:  mime.String(),
+		Extension: mime.Extension(),
+	}
+
+	switch {
+	case mime.Is("video/mp4"), mime.Is("video/quicktime"):
+		if !mp4HasVideoTrack(data) {
+			return info, fmt.Errorf("file has no video track: %s", info.MimeType)
+		}
+		info.setAnimated()
+	case mime.Is("video/webm"), mime.Is("video/x-matroska"):
+		info.setAnimated()
+	case mime.Is("image/gif"):
+		if gifIsAnimated(data) {
+			info.setAnimated()
+		} else {
+			info.setStill()
+		}
+	case mime.Is("image/webp"):
+		if webpIsAnimated(data) {
+			info.setAnimated()
+		} else {
+			info.setStill()
+		}
+	case mime.Is("image/vnd.mozilla.apng"):
+		info.setAnimated()
+	case mime.Is("image/png"), mime.Is("image/jpeg"):
+		info.setStill()
+	default:
+		return info, fmt.Errorf("unsupported file type: %s", info.MimeType)
+	}
+
+	return info, nil
+}
+
+func (f *FileInfo) setAnimated() {
+	f.Filetype = "video"
+	f.ContentType = "gif"
+}
+
+func (f *FileInfo) setStill() {
+	f.Filetype = "image"
+	f.ContentType = "pic"
+}
+
+// replaceExtension swaps the extension of filename for the detected one
+func replaceExtension(filename, extension string) string {
+	if extension == "" {
+		return filename
+	}
+	return strings.TrimSuffix(filename, path.Ext(filename)) + extension
+}
+
+// mp4HasVideoTrack walks the ISO-BMFF boxes (moov > trak > mdia > hdlr) looking for a video handler.
+// Files whose moov box can't be found are assumed to be video, since the mimetype already says so.
+func mp4HasVideoTrack(data []byte) bool {
+	moov, ok := findMP4Box(data, "moov")
+	if !ok {
+		return true
+	}
+
+	for _, trak := range findMP4Boxes(moov, "trak") {
+		mdia, ok := findMP4Box(trak, "mdia")
+		if !ok {
+			continue
+		}
+		hdlr, ok := findMP4Box(mdia, "hdlr")
+		// hdlr is a full box: version/flags (4), pre_defined (4), handler_type (4)
+		if ok && len(hdlr) >= 12 && string(hdlr[8:12]) == "vide" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// findMP4Box returns the payload of the first child box of the given type
+func findMP4Box(data []byte, boxType string) ([]byte, bool) {
+	boxes := findMP4Boxes(data, boxType)
+	if len(boxes) == 0 {
+		return nil, false
+	}
+	return boxes[0], true
+}
+
+// findMP4Boxes returns the payloads of every child box of the given type
+func findMP4Boxes(data []byte, boxType string) [][]byte {
+	var result [][]byte
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data[0:4]))
+		name := string(data[4:8])
+		header := uint64(8)
+
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return result
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			header = 16
+		}
+
+		if size < header || size > uint64(len(data)) {
+			return result
+		}
+		if name == boxType {
+			result = append(result, data[header:size])
+		}
+		data = data[size:]
+	}
+
+	return result
+}
+
+// gifIsAnimated walks the GIF blocks and reports whether there is more than one frame
+func gifIsAnimated(data []byte) bool {
+	if len(data) < 13 {
+		return false
+	}
+
+	pos := 13
+	// global color table
+	if data[10]&0x80 != 0 {
+		pos += 3 * (1 << (int(data[10]&0x07) + 1))
+	}
+
+	frames := 0
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21: // extension: introducer, label, then sub-blocks
+			pos = skipGIFSubBlocks(data, pos+2)
+		case 0x2C: // image descriptor
+			frames++
+			if frames > 1 {
+				return true
+			}
+			if pos+10 > len(data) {
+				return false
+			}
+			flags := data[pos+9]
+			pos += 10
+			// local color table
+			if flags&0x80 != 0 {
+				pos += 3 * (1 << (int(flags&0x07) + 1))
+			}
+			// LZW minimum code size, then the image data sub-blocks
+			pos = skipGIFSubBlocks(data, pos+1)
+		default: // trailer or garbage
+			return false
+		}
+	}
+
+	return false
+}
+
+// skipGIFSubBlocks returns the position right after a chain of GIF data sub-blocks
+func skipGIFSubBlocks(data []byte, pos int) int {
+	for pos < len(data) {
+		size := int(data[pos])
+		pos++
+		if size == 0 {
+			break
+		}
+		pos += size
+	}
+	return pos
+}
+
+// webpIsAnimated checks the animation flag of the extended (VP8X) WebP header
+func webpIsAnimated(data []byte) bool {
+	if len(data) < 21 || !bytes.Equal(data[12:16], []byte("VP8X")) {
+		return false
+	}
+	return data[20]&0x02 != 0
+}
@@ -0,0 +1,72 @@
+package bot
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"slices"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pocketbase/dbx"
+)
+
+const (
+	DeletePolicySoftDelete = "soft-delete"
+	DeletePolicyReview     = "review"
+	DeletePolicyIgnore     = "ignore"
+)
+
+// defaultGuildConfig is used for guilds without a "discord_guilds" record
+var defaultGuildConfig = GuildConfig{
+	DeletePolicy: DeletePolicyReview,
+	ReactionActions: map[string]string{
+		"⭐":  ReactionActionQuality,
+		"🚩":  ReactionActionReview,
+		"🗑️": ReactionActionDelete,
+	},
+}
+
+// loadGuildConfig reads the configuration of a guild, falling back to the defaults
+func loadGuildConfig(guildID string) GuildConfig {
+	config := defaultGuildConfig
+	config.GuildID = guildID
+
+	record, err := App.FindFirstRecordByFilter("discord_guilds", "guildId = {:guildId}", dbx.Params{"guildId": guildID})
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			slog.Error("UNABLE TO LOAD GUILD CONFIG", "GUILD", guildID, "MSG", err)
+		}
+		return config
+	}
+
+	if policy := record.GetString("deletePolicy"); policy != "" {
+		config.DeletePolicy = policy
+	}
+	if err := record.UnmarshalJSONField("curatorRoles", &config.CuratorRoles); err != nil {
+		slog.Warn("INVALID curatorRoles IN GUILD CONFIG", "GUILD", guildID, "MSG", err)
+	}
+
+	var reactionActions map[string]string
+	if err := record.UnmarshalJSONField("reactionActions", &reactionActions); err != nil {
+		slog.Warn("INVALID reactionActions IN GUILD CONFIG", "GUILD", guildID, "MSG", err)
+	} else if len(reactionActions) > 0 {
+		config.ReactionActions = reactionActions
+	}
+
+	return config
+}
+
+// isCurator reports whether a guild member has one of the curator roles of the guild
+func (c GuildConfig) isCurator(member *discordgo.Member) bool {
+	if member == nil {
+		return false
+	}
+
+	for _, role := range member.Roles {
+		if slices.Contains(c.CuratorRoles, role) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -6,6 +6,9 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -18,35 +21,22 @@ import (
 // App is the Pocketbase app instance that will be injected
 var App *pocketbase.PocketBase
 
-// retrieveImgurLinks takes a message content string and returns a slice of imgur media links
-func retrieveImgurLinks(content string) []string {
-	matches := imgurRegexp.FindAllStringSubmatch(content, -1)
-
-	var links []string
-	for _, match := range matches {
-		link := normalizeImgurLink(match)
-		links = append(links, link)
-	}
-
-	return links
-}
-
 // normalizeImgurMediaLink takes an imgur link and normalizes it to the expected format
 func normalizeImgurLink(imgurLink []string) string {
 	prefix := imgurLink[1]    // "i." or empty
 	mediaID := imgurLink[2]   // the alphanumeric ID
 	extension := imgurLink[3] // ".mp4" or empty
 
-	if prefix != "i." || extension == "" {
+	// .gifv is an HTML player page, the actual media lives at .mp4
+	if prefix != "i." || extension == "" || extension == ".gifv" {
 		return fmt.Sprintf("https://i.imgur.com/%s.mp4", mediaID)
 	}
 
 	return imgurLink[0]
 }
 
-// createMetadata takes a slice of role names and returns a Metadata struct
-func createMetadata(pingRoleNames []string) Metadata {
-	idolGroups := extractIdolAndGroupFromRoles(pingRoleNames)
+// createMetadata takes the idols and groups of the pinged roles and returns a Metadata struct
+func createMetadata(idolGroups []IdolGroup) Metadata {
 	idolNamesStr, groupNamesStr := getCommaSeparatedIdolAndGroupNames(idolGroups)
 
 	return Metadata{
@@ -69,6 +59,8 @@ func extractMetadata(content string, metadata *Metadata) error {
 				metadata.File = value
 			case "filetype":
 				metadata.Filetype = value
+			case "contenttype":
+				metadata.ContentType = value
 			case "title":
 				metadata.Title = value
 			case "idol":
@@ -121,6 +113,40 @@ func extractMetadata(content string, metadata *Metadata) error {
 }
 
 func createSetRecord(metadata Metadata) error {
+	// Use internal Pocketbase API instead of HTTP
+	collection, err := App.FindCollectionByNameOrId("contents_sets")
+	if err != nil {
+		slog.Error("ERROR FINDING COLLECTION", "MSG", err)
+		return err
+	}
+
+	record := core.NewRecord(collection)
+	record.Id = metadata.SetId
+	record.Set("discordGuildId", metadata.DiscordRef.GuildID)
+	record.Set("discordChannelId", metadata.DiscordRef.ChannelID)
+	record.Set("discordMessageId", metadata.DiscordRef.MessageID)
+	record.Set("discordAuthorId", metadata.DiscordRef.AuthorID)
+
+	fillSetRecord(record, metadata, time.Now())
+
+	uploaderIDs, err := metadata.uploaderIDs()
+	if err != nil {
+		slog.Error("ERROR CREATING UPLOADER", "MSG", err)
+		return err
+	}
+	record.Set("uploader", uploaderIDs)
+
+	if err := App.Save(record); err != nil {
+		slog.Error("ERROR SAVING RECORD", "MSG", err)
+		return err
+	}
+
+	return nil
+}
+
+// fillSetRecord sets the title, idols and groups of a "contents_sets" record from the metadata.
+// The title is prefixed with the metadata date, or fallbackDate when there is none.
+func fillSetRecord(record *core.Record, metadata Metadata, fallbackDate time.Time) {
 	var date string
 
 	if len(metadata.Date) == 6 {
@@ -128,15 +154,15 @@ func createSetRecord(metadata Metadata) error {
 		if err == nil {
 			date = t.Format("060102")
 		} else {
-			date = time.Now().Format("060102")
+			date = fallbackDate.Format("060102")
 		}
 	} else {
-		date = time.Now().Format("060102")
+		date = fallbackDate.Format("060102")
 	}
 
 	newTitle := fmt.Sprintf("%s %s", date, metadata.Title)
 
-	groupIDs := createGroupIDSet(metadata.Group, groupMap)
+	groupIDs := createGroupIDSet(metadata.Group, groupMap.snapshot())
 	var finalGroupIDs []string
 	for groupID := range groupIDs {
 		finalGroupIDs = append(finalGroupIDs, groupID)
@@ -151,41 +177,14 @@ func createSetRecord(metadata Metadata) error {
 		}
 	}
 
-	var finalUploaderIDs []string
-	uploaderNames := convertToStringSlice(metadata.Uploader)
-	for _, uploaderName := range uploaderNames {
-		id, err := lookupOrCreateUploader(uploaderName)
-		if err != nil {
-			slog.Error("ERROR CREATING UPLOADER", "MSG", err)
-			return err
-		}
-		finalUploaderIDs = append(finalUploaderIDs, id)
-	}
-
-	// Use internal Pocketbase API instead of HTTP
-	collection, err := App.FindCollectionByNameOrId("contents_sets")
-	if err != nil {
-		slog.Error("ERROR FINDING COLLECTION", "MSG", err)
-		return err
-	}
-
-	record := core.NewRecord(collection)
-	record.Id = metadata.SetId
 	record.Set("title", newTitle)
 	record.Set("idol", finalIdolIDs)
 	record.Set("group", finalGroupIDs)
-	record.Set("uploader", finalUploaderIDs)
-
-	if err := App.Save(record); err != nil {
-		slog.Error("ERROR SAVING RECORD", "MSG", err)
-		return err
-	}
-
-	return nil
 }
 
-// getRoleNamesFromIDs takes a slice of role IDs and returns their names, if found in the guild
-func getRoleNamesFromIDs(s *discordgo.Session, guildID string, roleIDs []string) ([]string, error) {
+// getRoleNamesFromIDs takes a slice of role IDs and returns their names by ID.
+// Roles that are not found in the guild are left out.
+func getRoleNamesFromIDs(s *discordgo.Session, guildID string, roleIDs []string) (map[string]string, error) {
 	guildRoles, err := s.GuildRoles(guildID)
 	if err != nil {
 		slog.Error("UNABLE TO GET GUILD ROLES", "MSG", err)
@@ -197,14 +196,14 @@ func getRoleNamesFromIDs(s *discordgo.Session, guildID string, roleIDs []string)
 		guildRoleMap[role.ID] = role.Name
 	}
 
-	roleNames := make([]string, 0, len(roleIDs))
+	roleNames := make(map[string]string, len(roleIDs))
 	for _, roleID := range roleIDs {
 		name, ok := guildRoleMap[roleID]
 		if !ok {
-			slog.Info("ROLE NOT FOUND", "MSG", name)
-			return nil, fmt.Errorf("ROLE NOT FOUND: %s", roleID)
+			slog.Info("ROLE NOT FOUND, SKIPPING", "ROLE", roleID)
+			continue
 		}
-		roleNames = append(roleNames, name)
+		roleNames[roleID] = name
 	}
 
 	return roleNames, nil
@@ -235,11 +234,12 @@ func getCommaSeparatedIdolAndGroupNames(idolGroups []IdolGroup) (string, string)
 	groupSet := make(map[string]struct{})
 
 	for _, ig := range idolGroups {
-		if _, exists := idolSet[ig.Idol]; !exists {
+		// roles can stand for a whole group, those have no idol
+		if _, exists := idolSet[ig.Idol]; !exists && ig.Idol != "" {
 			idolSet[ig.Idol] = struct{}{}
 			idolNames = append(idolNames, ig.Idol)
 		}
-		if _, exists := groupSet[ig.Group]; !exists {
+		if _, exists := groupSet[ig.Group]; !exists && ig.Group != "" {
 			groupSet[ig.Group] = struct{}{}
 			groupNames = append(groupNames, ig.Group)
 		}
@@ -253,63 +253,96 @@ func getCommaSeparatedIdolAndGroupNames(idolGroups []IdolGroup) (string, string)
 
 // processMediaLinks -> uploads a single record to "contents" using internal PB API
 func processMediaLinks(link, filename string, metadata Metadata) (string, error) {
-	// 1) Convert metadata to the new schema fields
+	// 1) Download the file and detect what it actually is
+	fileData, err := downloadFile(link, filename)
+	if err != nil {
+		return "", err
+	}
+
+	info, err := detectFileInfo(fileData)
+	if err != nil {
+		return "", err
+	}
+
+	metadata.Filetype = info.Filetype
+	// uploaders may mark items as "edit" or "compilation" themselves
+	if metadata.ContentType == "" {
+		metadata.ContentType = info.ContentType
+	}
+	filename = replaceExtension(filename, info.Extension)
+
+	// 2) Convert metadata to the new schema fields
 	metadataMap, err := metadata.parseMetadataToMap()
 	if err != nil {
 		slog.Error("UNABLE TO PARSE METADATA", "MSG", err)
 		return "", err
 	}
 
-	// 2) Get the collection
+	// 3) Get the collection
 	collection, err := App.FindCollectionByNameOrId("contents")
 	if err != nil {
 		slog.Error("ERROR FINDING COLLECTION", "MSG", err)
 		return "", err
 	}
 
-	// 3) Create a new record
+	// 4) Create a new record
 	record := core.NewRecord(collection)
 
 	// Set all fields from metadata map
+	applyMetadataMap(record, metadataMap)
+
+	// 5) Attach the file
+	file, err := filesystem.NewFileFromBytes(fileData, filename)
+	if err != nil {
+		return "", err
+	}
+
+	record.Set("file", file)
+
+	// 6) Save the record
+	if err := App.Save(record); err != nil {
+		slog.Error("ERROR SAVING RECORD", "MSG", err)
+		return "", err
+	}
+
+	return record.Id, nil
+}
+
+// applyMetadataMap sets the fields from parseMetadataToMap on a "contents" record
+func applyMetadataMap(record *core.Record, metadataMap map[string]string) {
 	for key, value := range metadataMap {
 		// Parse JSON arrays for relation fields
-		if key == "idol" || key == "group" || key == "uploader" {
+		if key == "idol" || key == "group" || key == "uploader" || key == "tag" {
 			var ids []string
 			if err := json.Unmarshal([]byte(value), &ids); err == nil {
 				record.Set(key, ids)
 			}
-		} else if key == "tag" {
-			var tags []string
-			if err := json.Unmarshal([]byte(value), &tags); err == nil {
-				record.Set(key, tags)
-			}
 		} else {
 			record.Set(key, value)
 		}
 	}
+}
 
-	// 4) Download and attach the file
-	fileData, err := downloadFile(link, filename)
-	if err != nil {
-		return "", err
-	}
+// maxDownloadSize is the largest file downloaded for an upload, the maxSize of the "contents" file field.
+// It can be changed in megabytes with MAX_DOWNLOAD_MB.
+var maxDownloadSize int64 = 50 << 20
 
-	file, err := filesystem.NewFileFromBytes(fileData, filename)
-	if err != nil {
-		return "", err
+// configureDownloadLimit reads MAX_DOWNLOAD_MB, an invalid value keeps the default
+func configureDownloadLimit() {
+	value := os.Getenv("MAX_DOWNLOAD_MB")
+	if value == "" {
+		return
 	}
 
-	record.Set("file", file)
-
-	// 5) Save the record
-	if err := App.Save(record); err != nil {
-		slog.Error("ERROR SAVING RECORD", "MSG", err)
-		return "", err
+	megabytes, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || megabytes <= 0 {
+		slog.Warn("INVALID MAX_DOWNLOAD_MB, USING THE DEFAULT", "VALUE", value)
+		return
 	}
-
-	return record.Id, nil
+	maxDownloadSize = megabytes << 20
 }
 
+// downloadFile downloads an upload, files over maxDownloadSize are rejected without being read whole
 func downloadFile(link string, filename string) ([]byte, error) {
 	client := &http.Client{}
 
@@ -329,11 +362,18 @@ func downloadFile(link string, filename string) ([]byte, error) {
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("failed to download file: %s", resp.Status)
 	}
+	if resp.ContentLength > maxDownloadSize {
+		return nil, fmt.Errorf("file is larger than %d bytes: %d bytes", maxDownloadSize, resp.ContentLength)
+	}
 
-	fileData, err := io.ReadAll(resp.Body)
+	// the length header can be missing or wrong, one byte more than allowed tells the file is too large
+	fileData, err := io.ReadAll(io.LimitReader(resp.Body, maxDownloadSize+1))
 	if err != nil {
 		return nil, err
 	}
+	if int64(len(fileData)) > maxDownloadSize {
+		return nil, fmt.Errorf("file is larger than %d bytes", maxDownloadSize)
+	}
 
 	if len(fileData) == 0 {
 		return nil, fmt.Errorf("downloaded file is empty")
@@ -342,11 +382,11 @@ func downloadFile(link string, filename string) ([]byte, error) {
 	return fileData, nil
 }
 
-func createGroupIDSet(groupPlain string, groupMap map[string]string) map[string]bool {
+func createGroupIDSet(groupPlain string, groupIDs map[string]string) map[string]bool {
 	groups := convertToStringSlice(groupPlain)
 	set := make(map[string]bool)
 	for _, group := range groups {
-		if groupID, ok := groupMap[group]; ok {
+		if groupID, ok := groupIDs[group]; ok {
 			set[groupID] = true
 		}
 	}
@@ -354,7 +394,7 @@ func createGroupIDSet(groupPlain string, groupMap map[string]string) map[string]
 }
 
 func getIdolIDByGroup(idol string, groupIDSet map[string]bool) (string, bool) {
-	idols, exist := idolMap[idol]
+	idols, exist := idolMap.get(idol)
 	if !exist {
 		return "", false
 	}
@@ -373,7 +413,7 @@ func getIdolIDByGroup(idol string, groupIDSet map[string]bool) (string, bool) {
 	}
 }
 
-// loadGroupsFromDB loads groups from Pocketbase database
+// loadGroupsFromDB loads groups from Pocketbase database, keyed by both name and aliases
 func loadGroupsFromDB() (map[string]string, error) {
 	records, err := App.FindRecordsByFilter("groups", "", "-created", 0, 0)
 	if err != nil {
@@ -385,10 +425,74 @@ func loadGroupsFromDB() (map[string]string, error) {
 		name := strings.ToLower(strings.TrimSpace(record.GetString("name")))
 		m[name] = record.Id
 	}
+	addAliases(m, records)
 
 	return m, nil
 }
 
+// recordAliases returns the lowercased "aliases" of a groups, groups_idols or tags record
+func recordAliases(record *core.Record) []string {
+	var aliases []string
+	if err := record.UnmarshalJSONField("aliases", &aliases); err != nil {
+		slog.Warn("INVALID aliases", "COLLECTION", record.Collection().Name, "ID", record.Id, "MSG", err)
+		return nil
+	}
+
+	for i, alias := range aliases {
+		aliases[i] = strings.ToLower(strings.TrimSpace(alias))
+	}
+	return aliases
+}
+
+// addAliases adds the aliases of the records to a name -> ID mapping, names always win over aliases
+func addAliases(m map[string]string, records []*core.Record) {
+	for _, record := range records {
+		for _, alias := range recordAliases(record) {
+			if _, exists := m[alias]; !exists && alias != "" {
+				m[alias] = record.Id
+			}
+		}
+	}
+}
+
+// loadTagsFromDB loads tags from Pocketbase database, keyed by name, code and aliases
+func loadTagsFromDB() (map[string]string, error) {
+	records, err := App.FindRecordsByFilter("tags", "", "-created", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[string]string, len(records))
+	for _, record := range records {
+		name := strings.ToLower(strings.TrimSpace(record.GetString("name")))
+		m[name] = record.Id
+		if code := strings.ToLower(strings.TrimSpace(record.GetString("code"))); code != "" {
+			if _, exists := m[code]; !exists {
+				m[code] = record.Id
+			}
+		}
+	}
+	addAliases(m, records)
+
+	return m, nil
+}
+
+// loadNamesFromDB returns a loader of the names of a collection by record ID, as they are written in the database
+func loadNamesFromDB(collection string) func() (map[string]string, error) {
+	return func() (map[string]string, error) {
+		records, err := App.FindRecordsByFilter(collection, "", "", 0, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		m := make(map[string]string, len(records))
+		for _, record := range records {
+			m[record.Id] = strings.TrimSpace(record.GetString("name"))
+		}
+		return m, nil
+	}
+}
+
 // loadUploadersFromDB loads uploaders from Pocketbase database
 func loadUploadersFromDB() (map[string]string, error) {
 	records, err := App.FindRecordsByFilter("uploaders", "", "-created", 0, 0)
@@ -405,7 +509,7 @@ func loadUploadersFromDB() (map[string]string, error) {
 	return m, nil
 }
 
-// loadIdolsFromDB loads idols from Pocketbase database
+// loadIdolsFromDB loads idols from Pocketbase database, keyed by both name and aliases
 func loadIdolsFromDB() (map[string][]IdolItem, error) {
 	records, err := App.FindRecordsByFilter("groups_idols", "", "-created", 0, 0)
 	if err != nil {
@@ -424,10 +528,24 @@ func loadIdolsFromDB() (map[string][]IdolItem, error) {
 		m[name] = append(m[name], item)
 	}
 
+	// idols share names anyway, so an alias is listed next to the idols actually named like it
+	for _, record := range records {
+		for _, alias := range recordAliases(record) {
+			if alias == "" || slices.ContainsFunc(m[alias], func(idol IdolItem) bool { return idol.ID == record.Id }) {
+				continue
+			}
+			m[alias] = append(m[alias], IdolItem{
+				ID:    record.Id,
+				Name:  record.GetString("name"),
+				Code:  record.GetString("code"),
+				Group: record.GetString("group"),
+			})
+		}
+	}
+
 	return m, nil
 }
 
-
 func createUploaderInPB(uploaderName string) (string, error) {
 	// Use internal Pocketbase API instead of HTTP
 	collection, err := App.FindCollectionByNameOrId("uploaders")
@@ -448,7 +566,7 @@ func createUploaderInPB(uploaderName string) (string, error) {
 }
 
 func lookupOrCreateUploader(uploaderName string) (string, error) {
-	id, found := uploaderMap[uploaderName]
+	id, found := uploaderMap.get(uploaderName)
 	if found {
 		return id, nil
 	}
@@ -459,7 +577,7 @@ func lookupOrCreateUploader(uploaderName string) (string, error) {
 		return "", err
 	}
 
-	uploaderMap[uploaderName] = newID
+	uploaderMap.update(func(uploaders map[string]string) { uploaders[uploaderName] = newID })
 
 	return newID, nil
 }
@@ -486,10 +604,41 @@ func convertToStringSlice(s string) []string {
 	return result
 }
 
+// tagIDs resolves the tag names of the metadata, names that aren't a tag are left out
+func (m Metadata) tagIDs() []string {
+	var ids []string
+	for _, name := range convertToStringSlice(m.Tags) {
+		id, ok := tagMap.get(name)
+		if !ok {
+			slog.Info("TAG NOT FOUND, SKIPPING", "TAG", name)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
 // parseMetadataToMap modifies how we pass data to the new "contents" collection.
+// The uploader is resolved here too, creating it when it is new.
 func (m Metadata) parseMetadataToMap() (map[string]string, error) {
+	metadataMap := m.metadataFields()
+
+	uploaderIDs, err := m.uploaderIDs()
+	if err != nil {
+		slog.Error("UNABLE TO CREATE UPLOADER: ", "MSG", err)
+		return nil, err
+	}
+	uploaderJSON, _ := json.Marshal(uploaderIDs)
+	metadataMap["uploader"] = string(uploaderJSON)
+
+	return metadataMap, nil
+}
+
+// metadataFields converts the metadata to "contents" fields without the uploader.
+// Names are only looked up, so re-reading the metadata of a post never creates records.
+func (m Metadata) metadataFields() map[string]string {
 	// 1) Build a set of group IDs from m.Group
-	groupIDSet := createGroupIDSet(m.Group, groupMap)
+	groupIDSet := createGroupIDSet(m.Group, groupMap.snapshot())
 	// e.g. if "IVE, NewJeans" => { "mg12ovw2liil5j4":true, "njs999":true }
 
 	// 2) For each idol in m.Idol, find a matching record among idolRepo
@@ -507,60 +656,31 @@ func (m Metadata) parseMetadataToMap() (map[string]string, error) {
 	for gID := range groupIDSet {
 		finalGroupIDs = append(finalGroupIDs, gID)
 	}
-	// Instead of calling namesToIDs for uploader:
-	var uploaderIDs []string
-	uploaderNames := convertToStringSlice(m.Uploader)
-	for _, name := range uploaderNames {
-		id, err := lookupOrCreateUploader(name)
-		if err != nil {
-			slog.Error("UNABLE TO CREATE UPLOADER: ", "MSG", err)
-			return nil, err
-		}
-		uploaderIDs = append(uploaderIDs, id)
-	}
 
 	idolJSON, _ := json.Marshal(finalIdolIDs)
 	groupJSON, _ := json.Marshal(finalGroupIDs)
-	uploaderJSON, _ := json.Marshal(uploaderIDs)
-
-	// "tag" is also an array in new PB. If you want to allow multiple tags,
-	// parse m.Tags by comma and build a JSON array.
-	tagsSlice := []string{}
-	if m.Tags != "" {
-		for _, t := range strings.Split(m.Tags, ",") {
-			trimmed := strings.TrimSpace(t)
-			if trimmed != "" {
-				tagsSlice = append(tagsSlice, trimmed)
-			}
-		}
-	}
-	// Convert slice to JSON array string
-	var tagArrayStr string
-	if len(tagsSlice) == 0 {
-		tagArrayStr = "[]"
-	} else {
-		quotedTags := []string{}
-		for _, t := range tagsSlice {
-			quotedTags = append(quotedTags, fmt.Sprintf("\"%s\"", t))
-		}
-		tagArrayStr = fmt.Sprintf("[%s]", strings.Join(quotedTags, ","))
-	}
+	tagJSON, _ := json.Marshal(m.tagIDs())
 
 	metadataMap := map[string]string{
 		// new PB "contents" fields
 		"title": m.Title,
-		// pass idol/group/uploader as JSON array strings
-		"idol":     string(idolJSON), // e.g. ["YujinID"] if you have real IDs
-		"group":    string(groupJSON),
-		"uploader": string(uploaderJSON),
-		"tag":      tagArrayStr,
-
-		"filetype": m.Filetype,
-		"date":     m.Date,
-		"source":   m.Source,
-		"discord":  m.Discord,
-		"mirror":   m.Mirror,
-		"hqMirror": m.HqMirror,
+		// pass idol/group/tag as JSON array strings
+		"idol":  string(idolJSON), // e.g. ["YujinID"] if you have real IDs
+		"group": string(groupJSON),
+		"tag":   string(tagJSON),
+
+		"filetype":    m.Filetype,
+		"contenttype": m.ContentType,
+		"date":        m.Date,
+		"source":      m.Source,
+		"discord":     m.Discord,
+
+		"discordGuildId":   m.DiscordRef.GuildID,
+		"discordChannelId": m.DiscordRef.ChannelID,
+		"discordMessageId": m.DiscordRef.MessageID,
+		"discordAuthorId":  m.DiscordRef.AuthorID,
+		"mirror":           m.Mirror,
+		"hqMirror":         m.HqMirror,
 		// "set" is a single relation - we can pass one ID if we have a real "contents_sets" record
 		"set": m.SetId,
 
@@ -570,7 +690,7 @@ func (m Metadata) parseMetadataToMap() (map[string]string, error) {
 	}
 
 	// If user typed a special date format "now" or "today", handle that
-	if m.Date == "now" || m.Date == "today" {
+	if isRelativeDate(m.Date) {
 		metadataMap["date"] = time.Now().Format(time.RFC3339Nano)
 	} else {
 		// If user typed YYMMDD
@@ -580,5 +700,5 @@ func (m Metadata) parseMetadataToMap() (map[string]string, error) {
 			}
 		}
 	}
-	return metadataMap, nil
+	return metadataMap
 }
@@ -0,0 +1,92 @@
+package bot
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMetadataFieldsOnlyLooksUp(t *testing.T) {
+	app := newTestApp(t)
+
+	group := createTestRecord(t, app, "groups", map[string]any{"name": "IVE"})
+	idol := createTestRecord(t, app, "groups_idols", map[string]any{"name": "Yujin", "group": group.Id})
+	tag := createTestRecord(t, app, "tags", map[string]any{"name": "Fancam", "code": "fancam"})
+	loadTestMappings(t)
+
+	metadata := Metadata{}
+	content := "title: stage\nidol: Yujin\ngroup: IVE\ntags: Fancam, not a tag\nuploader: someone new"
+	if err := extractMetadata(content, &metadata); err != nil {
+		t.Fatal(err)
+	}
+	fields := metadata.metadataFields()
+
+	for field, want := range map[string][]string{"idol": {idol.Id}, "group": {group.Id}, "tag": {tag.Id}} {
+		var ids []string
+		if err := json.Unmarshal([]byte(fields[field]), &ids); err != nil {
+			t.Fatalf("%s = %q: %v", field, fields[field], err)
+		}
+		if !slices.Equal(ids, want) {
+			t.Errorf("%s = %v, want %v", field, ids, want)
+		}
+	}
+	if _, ok := fields["uploader"]; ok {
+		t.Errorf("uploader = %q, want it left out", fields["uploader"])
+	}
+
+	for _, collection := range []string{"uploaders", "tags"} {
+		total, err := app.CountRecords(collection)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := map[string]int64{"uploaders": 0, "tags": 1}[collection]; total != want {
+			t.Errorf("%d %s, want %d", total, collection, want)
+		}
+	}
+}
+
+func TestDownloadFileRejectsLargeFiles(t *testing.T) {
+	previous := maxDownloadSize
+	maxDownloadSize = 10
+	t.Cleanup(func() { maxDownloadSize = previous })
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+		if r.URL.Query().Has("chunked") {
+			// no Content-Length, the size is only known while reading
+			w.(http.Flusher).Flush()
+		} else {
+			w.Header().Set("Content-Length", strconv.Itoa(size))
+		}
+		w.Write([]byte(strings.Repeat("x", size)))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		query   string
+		wantErr bool
+	}{
+		{"at the limit", "size=10", false},
+		{"over the limit", "size=11", true},
+		{"over the limit without a length", "size=11&chunked", true},
+		{"under the limit without a length", "size=3&chunked", false},
+		{"empty", "size=0", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := downloadFile(server.URL+"/file.mp4?"+tt.query, "file.mp4")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && int64(len(data)) > maxDownloadSize {
+				t.Errorf("downloaded %d bytes, over the limit", len(data))
+			}
+		})
+	}
+}
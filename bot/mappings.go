@@ -0,0 +1,87 @@
+package bot
+
+import (
+	"maps"
+	"sync"
+	"sync/atomic"
+)
+
+// nameMapping is a lookup by lowercased name shared by every handler. discordgo runs handlers
+// concurrently, so a published map is never changed: writers copy it, change the copy and swap
+// it in, while readers use whatever map is current without locking.
+type nameMapping[V any] struct {
+	// mu serializes writers, so concurrent changes don't drop each other
+	mu      sync.Mutex
+	current atomic.Pointer[map[string]V]
+}
+
+// snapshot returns the current map, it must not be changed
+func (m *nameMapping[V]) snapshot() map[string]V {
+	if current := m.current.Load(); current != nil {
+		return *current
+	}
+	return nil
+}
+
+// get looks a name up in the current map
+func (m *nameMapping[V]) get(name string) (V, bool) {
+	value, ok := m.snapshot()[name]
+	return value, ok
+}
+
+// replace publishes a whole new map
+func (m *nameMapping[V]) replace(values map[string]V) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.current.Store(&values)
+}
+
+// reload publishes a freshly loaded map, loading under the writer lock so an older load
+// can't overwrite a newer one, and a failed load keeps the current map
+func (m *nameMapping[V]) reload(load func() (map[string]V, error)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	values, err := load()
+	if err != nil {
+		return err
+	}
+	m.current.Store(&values)
+	return nil
+}
+
+// update publishes a copy of the current map with the changes fn makes to it
+func (m *nameMapping[V]) update(fn func(values map[string]V)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.publish(fn)
+}
+
+// publish stores a changed copy of the current map, the writer lock must be held
+func (m *nameMapping[V]) publish(fn func(values map[string]V)) {
+	values := maps.Clone(m.snapshot())
+	if values == nil {
+		values = make(map[string]V)
+	}
+	fn(values)
+	m.current.Store(&values)
+}
+
+// getOrCreate returns the value of a name, calling create and publishing its value when it is
+// missing. create runs under the writer lock, so concurrent callers create a name only once.
+func (m *nameMapping[V]) getOrCreate(name string, create func() (V, error)) (V, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if value, ok := m.snapshot()[name]; ok {
+		return value, nil
+	}
+
+	value, err := create()
+	if err != nil {
+		return value, err
+	}
+
+	m.publish(func(values map[string]V) { values[name] = value })
+	return value, nil
+}
@@ -0,0 +1,576 @@
+package bot
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// myUploadsPrefix prefixes the CustomIDs of the /myuploads controls and modals,
+// followed by "<action>:<kind>:<record ID>"
+const myUploadsPrefix = "myuploads:"
+
+// myUploadsLimit is how many recent uploads /myuploads offers, the most a select menu holds.
+// The list is not on the shared paginator: that one pages read-only text for everyone in the channel
+// and keeps a session per message, while this is an ephemeral picker whose options carry the records
+// to manage. Older uploads are still changed by editing or deleting their post.
+const myUploadsLimit = 25
+
+// uploadKinds are the collections of the kinds of uploads /myuploads manages
+var uploadKinds = map[string]string{
+	"item": "contents",
+	"set":  "contents_sets",
+}
+
+// uploadEditFields are the "contents" fields the /myuploads edit modal changes
+var uploadEditFields = []string{"title", "idol", "group", "tag", "date"}
+
+var myUploadsCommand = &discordgo.ApplicationCommand{
+	Name:        "myuploads",
+	Description: "Edit or delete your recent uploads.",
+}
+
+// handleMyUploadsCommand lists the recent uploads of the user, only they see the list
+func handleMyUploadsCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	content, embeds, components, err := myUploadsView(getUserID(i), nil, "")
+	if err != nil {
+		log.Printf("myuploads: unable to list uploads: %v", err)
+		respondEphemeral(s, i.Interaction, "Could not load your uploads.")
+		return
+	}
+
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content:    content,
+			Embeds:     embeds,
+			Components: components,
+			Flags:      discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		log.Printf("Error responding to /myuploads: %v", err)
+	}
+}
+
+// handleMyUploadsComponent handles the upload picker and the edit and delete buttons of /myuploads
+func handleMyUploadsComponent(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	data := i.MessageComponentData()
+	action, target, _ := strings.Cut(strings.TrimPrefix(data.CustomID, myUploadsPrefix), ":")
+	if action == "pick" && len(data.Values) > 0 {
+		target = data.Values[0]
+	}
+
+	kind, id, _ := strings.Cut(target, ":")
+	record, err := findOwnedUpload(kind, id, getUserID(i))
+	if err != nil {
+		respondEphemeral(s, i.Interaction, err.Error())
+		return
+	}
+
+	switch action {
+	case "pick", "show":
+		respondMyUploads(s, i, record, "")
+	case "edit":
+		openUploadModal(s, i, record, "edit")
+	case "source":
+		openUploadModal(s, i, record, "source")
+	case "delete":
+		respondDeleteConfirmation(s, i, record)
+	case "confirm":
+		if err := deleteUpload(record); err != nil {
+			log.Printf("myuploads: unable to delete %s %s: %v", kind, record.Id, err)
+			respondEphemeral(s, i.Interaction, "Could not delete the upload.")
+			return
+		}
+		recordAudit(AuditEntry{
+			Action:    "myuploads:delete",
+			ActorID:   getUserID(i),
+			GuildID:   i.GuildID,
+			ChannelID: i.ChannelID,
+			Records:   []string{record.Id},
+			Details:   map[string]any{"kind": kind},
+		})
+		respondMyUploads(s, i, nil, fmt.Sprintf("🗑️ Deleted **%s**.", uploadTitle(record)))
+	default:
+		respondEphemeral(s, i.Interaction, "Unknown action.")
+	}
+}
+
+// handleMyUploadsModal saves the changes submitted in the edit modals of /myuploads
+func handleMyUploadsModal(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	data := i.ModalSubmitData()
+	action, target, _ := strings.Cut(strings.TrimPrefix(data.CustomID, myUploadsPrefix), ":")
+	kind, id, _ := strings.Cut(target, ":")
+
+	// ownership is checked again, the upload may have changed hands since the modal opened
+	record, err := findOwnedUpload(kind, id, getUserID(i))
+	if err != nil {
+		respondEphemeral(s, i.Interaction, err.Error())
+		return
+	}
+
+	values := modalValues(data)
+	switch action {
+	case "edit":
+		err = editUpload(record, values)
+	case "source":
+		err = editUploadSource(record, values["source"])
+	default:
+		err = fmt.Errorf("Unknown action.")
+	}
+	if err != nil {
+		respondEphemeral(s, i.Interaction, fmt.Sprintf("%s\nNothing was changed.", err))
+		return
+	}
+
+	recordAudit(AuditEntry{
+		Action:    "myuploads:" + action,
+		ActorID:   getUserID(i),
+		GuildID:   i.GuildID,
+		ChannelID: i.ChannelID,
+		Records:   []string{record.Id},
+		Details:   map[string]any{"kind": kind, "values": values},
+	})
+
+	respondMyUploads(s, i, record, "✅ Saved.")
+}
+
+// uploaderOfUser returns the uploader of a Discord user, nil when they never uploaded anything
+func uploaderOfUser(discordID string) (*core.Record, error) {
+	record, err := App.FindFirstRecordByFilter("uploaders", "discordId = {:discordId}", dbx.Params{"discordId": discordID})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return record, err
+}
+
+// findOwnedUpload loads an upload that isn't deleted, as long as the user is one of its uploaders.
+// The errors are meant for the user.
+func findOwnedUpload(kind, id, discordID string) (*core.Record, error) {
+	collection, ok := uploadKinds[kind]
+	if !ok || id == "" {
+		return nil, fmt.Errorf("Unknown upload.")
+	}
+
+	record, err := App.FindRecordById(collection, id)
+	if err != nil || !record.GetDateTime("deleted").IsZero() {
+		return nil, fmt.Errorf("This upload no longer exists.")
+	}
+
+	uploader, err := uploaderOfUser(discordID)
+	if err != nil {
+		log.Printf("myuploads: unable to look up the uploader of %s: %v", discordID, err)
+		return nil, fmt.Errorf("Could not check who uploaded this.")
+	}
+	if uploader == nil || !slices.Contains(record.GetStringSlice("uploader"), uploader.Id) {
+		return nil, fmt.Errorf("Only the uploader can change this upload.")
+	}
+
+	return record, nil
+}
+
+// recentUploads returns the most recent items and sets of an uploader, newest first
+func recentUploads(uploaderID string) ([]*core.Record, error) {
+	items, err := newContentsQuery().withUploader(uploaderID).find("-created", myUploadsLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	sets, err := App.FindRecordsByFilter("contents_sets", "deleted = '' && uploader:each ?= {:uploader}", "-created", myUploadsLimit, 0,
+		dbx.Params{"uploader": uploaderID})
+	if err != nil {
+		return nil, err
+	}
+
+	uploads := append(items, sets...)
+	slices.SortStableFunc(uploads, func(a, b *core.Record) int {
+		return b.GetDateTime("created").Time().Compare(a.GetDateTime("created").Time())
+	})
+
+	return uploads[:min(len(uploads), myUploadsLimit)], nil
+}
+
+// uploadKind returns the /myuploads kind of a "contents" or "contents_sets" record
+func uploadKind(record *core.Record) string {
+	if record.Collection().Name == uploadKinds["set"] {
+		return "set"
+	}
+	return "item"
+}
+
+// uploadTitle returns the title of an upload, which may be empty for sets
+func uploadTitle(record *core.Record) string {
+	if title := record.GetString("title"); title != "" {
+		return title
+	}
+	return "Untitled " + uploadKind(record)
+}
+
+// myUploadsView renders the list of recent uploads of a user, with the controls of the selected one
+func myUploadsView(discordID string, selected *core.Record, notice string) (string, []*discordgo.MessageEmbed, []discordgo.MessageComponent, error) {
+	embeds := []*discordgo.MessageEmbed{}
+	components := []discordgo.MessageComponent{}
+
+	uploader, err := uploaderOfUser(discordID)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	var uploads []*core.Record
+	if uploader != nil {
+		if uploads, err = recentUploads(uploader.Id); err != nil {
+			return "", nil, nil, err
+		}
+	}
+	if len(uploads) == 0 {
+		return strings.TrimSpace(notice + "\nYou have no uploads."), embeds, components, nil
+	}
+
+	content := "Your most recent uploads, pick one to edit or delete it."
+	if notice != "" {
+		content = notice + "\n" + content
+	}
+
+	options := make([]discordgo.SelectMenuOption, 0, len(uploads))
+	for _, upload := range uploads {
+		description := "Item"
+		if uploadKind(upload) == "set" {
+			description = "Set"
+		}
+		options = append(options, discordgo.SelectMenuOption{
+			Label:       truncateRunes(uploadTitle(upload), 100),
+			Value:       uploadKind(upload) + ":" + upload.Id,
+			Description: description + ", uploaded " + upload.GetDateTime("created").Time().Format("2006-01-02"),
+			Default:     selected != nil && selected.Id == upload.Id,
+		})
+	}
+	components = append(components, discordgo.ActionsRow{
+		Components: []discordgo.MessageComponent{
+			discordgo.SelectMenu{CustomID: myUploadsPrefix + "pick", Placeholder: "Pick an upload", Options: options},
+		},
+	})
+
+	if selected == nil {
+		return content, embeds, components, nil
+	}
+
+	embed, url := uploadEmbed(selected)
+	embeds = append(embeds, embed)
+	components = append(components, uploadControls(selected, url))
+
+	return content, embeds, components, nil
+}
+
+// respondMyUploads replaces the /myuploads message with the list and the selected upload
+func respondMyUploads(s *discordgo.Session, i *discordgo.InteractionCreate, selected *core.Record, notice string) {
+	content, embeds, components, err := myUploadsView(getUserID(i), selected, notice)
+	if err != nil {
+		log.Printf("myuploads: unable to list uploads: %v", err)
+		respondEphemeral(s, i.Interaction, "Could not load your uploads.")
+		return
+	}
+
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content:    content,
+			Embeds:     embeds,
+			Components: components,
+		},
+	})
+	if err != nil {
+		log.Printf("Error updating /myuploads: %v", err)
+	}
+}
+
+// respondDeleteConfirmation asks the user to confirm deleting an upload
+func respondDeleteConfirmation(s *discordgo.Session, i *discordgo.InteractionCreate, record *core.Record) {
+	target := uploadKind(record) + ":" + record.Id
+	content := fmt.Sprintf("Delete **%s**? It disappears from KpopCat.", uploadTitle(record))
+	if uploadKind(record) == "set" {
+		content = fmt.Sprintf("Delete the set **%s** and all of its items? They disappear from KpopCat.", uploadTitle(record))
+	}
+
+	embed, _ := uploadEmbed(record)
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content: content,
+			Embeds:  []*discordgo.MessageEmbed{embed},
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.Button{CustomID: myUploadsPrefix + "confirm:" + target, Label: "Delete", Style: discordgo.DangerButton},
+						discordgo.Button{CustomID: myUploadsPrefix + "show:" + target, Label: "Cancel", Style: discordgo.SecondaryButton},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("Error confirming /myuploads delete: %v", err)
+	}
+}
+
+// uploadEmbed renders an upload and returns its link on the site
+func uploadEmbed(record *core.Record) (*discordgo.MessageEmbed, string) {
+	if uploadKind(record) == "item" {
+		expandForEmbed(record)
+		return contentEmbed(record), contentSiteURL(record)
+	}
+
+	items, err := newContentsQuery().inSet(record.Id).find("created", 0)
+	if err != nil {
+		log.Printf("myuploads: unable to load the items of set %s: %v", record.Id, err)
+	}
+	expandForEmbed(items...)
+	return setEmbed(record, items), fmt.Sprintf("%s/set/%s", siteURL, record.Id)
+}
+
+// uploadControls are the edit and delete buttons of an upload
+func uploadControls(record *core.Record, url string) discordgo.ActionsRow {
+	target := uploadKind(record) + ":" + record.Id
+
+	buttons := []discordgo.MessageComponent{
+		discordgo.Button{CustomID: myUploadsPrefix + "edit:" + target, Label: "Edit", Emoji: &discordgo.ComponentEmoji{Name: "✏️"}, Style: discordgo.PrimaryButton},
+	}
+	// modals hold at most five inputs, the source of items gets its own
+	if uploadKind(record) == "item" {
+		buttons = append(buttons, discordgo.Button{CustomID: myUploadsPrefix + "source:" + target, Label: "Edit source", Style: discordgo.SecondaryButton})
+	}
+	buttons = append(buttons, discordgo.Button{CustomID: myUploadsPrefix + "delete:" + target, Label: "Delete", Emoji: &discordgo.ComponentEmoji{Name: "🗑️"}, Style: discordgo.DangerButton})
+	if url != "" {
+		buttons = append(buttons, discordgo.Button{Label: "Open on KpopCat", Style: discordgo.LinkButton, URL: url})
+	}
+
+	return discordgo.ActionsRow{Components: buttons}
+}
+
+// openUploadModal opens the edit or source modal of an upload, filled in with its current values
+func openUploadModal(s *discordgo.Session, i *discordgo.InteractionCreate, record *core.Record, action string) {
+	kind := uploadKind(record)
+	modal := &discordgo.InteractionResponseData{
+		CustomID: myUploadsPrefix + action + ":" + kind + ":" + record.Id,
+		Title:    "Edit " + kind,
+	}
+
+	if action == "source" {
+		modal.Components = []discordgo.MessageComponent{
+			prefilledTextInput("source", "Source", "Link to the original video, empty for none", record.GetString("source"), false),
+		}
+	} else {
+		values := uploadEditValues(record)
+		datePlaceholder := "YYMMDD, empty for none"
+		if kind == "set" {
+			datePlaceholder = "YYMMDD, defaults to the upload date"
+		}
+		modal.Components = []discordgo.MessageComponent{
+			prefilledTextInput("title", "Title", "Defaults to \"<idol> from <group>\"", values["title"], false),
+			prefilledTextInput("idol", "Idols", "Comma separated, e.g. Yujin, Wonyoung", values["idol"], true),
+			prefilledTextInput("group", "Groups", "Comma separated, e.g. IVE", values["group"], true),
+			prefilledTextInput("tags", "Tags", "Comma separated, optional", values["tags"], false),
+			prefilledTextInput("date", "Date", datePlaceholder, values["date"], false),
+		}
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseModal,
+		Data: modal,
+	})
+	if err != nil {
+		log.Printf("Error opening /myuploads modal: %v", err)
+	}
+}
+
+// prefilledTextInput is an /upload modal input holding a current value
+func prefilledTextInput(customID, label, placeholder, value string, required bool) discordgo.ActionsRow {
+	row := uploadTextInput(customID, label, placeholder, required)
+	input := row.Components[0].(discordgo.TextInput)
+	input.Value = truncateRunes(value, input.MaxLength)
+	row.Components[0] = input
+	return row
+}
+
+// uploadEditValues returns the current title, idols, groups, tags and date of an upload as the edit modal takes them.
+// Sets store their date as a prefix of the title and have no tags, the tags of their items are used instead.
+func uploadEditValues(record *core.Record) map[string]string {
+	values := map[string]string{"title": record.GetString("title")}
+
+	var tagsOf []*core.Record
+	if uploadKind(record) == "set" {
+		if errs := App.ExpandRecord(record, []string{"idol", "group"}, nil); len(errs) > 0 {
+			log.Printf("myuploads: unable to expand set %s: %v", record.Id, errs)
+		}
+		values["date"], values["title"] = splitSetTitle(values["title"])
+
+		items, err := newContentsQuery().inSet(record.Id).find("created", 0)
+		if err != nil {
+			log.Printf("myuploads: unable to load the items of set %s: %v", record.Id, err)
+		}
+		if len(items) > 0 {
+			if errs := App.ExpandRecords(items, []string{"tag"}, nil); len(errs) > 0 {
+				log.Printf("myuploads: unable to expand the items of set %s: %v", record.Id, errs)
+			}
+		}
+		tagsOf = items
+	} else {
+		if errs := App.ExpandRecord(record, []string{"idol", "group", "tag"}, nil); len(errs) > 0 {
+			log.Printf("myuploads: unable to expand item %s: %v", record.Id, errs)
+		}
+		if date := record.GetDateTime("date"); !date.IsZero() {
+			values["date"] = date.Time().Format("060102")
+		}
+		tagsOf = []*core.Record{record}
+	}
+
+	values["idol"] = strings.Join(relatedNames(record, "idol"), ", ")
+	values["group"] = strings.Join(relatedNames(record, "group"), ", ")
+
+	var tags []string
+	for _, item := range tagsOf {
+		for _, name := range relatedNames(item, "tag") {
+			if !slices.Contains(tags, name) {
+				tags = append(tags, name)
+			}
+		}
+	}
+	values["tags"] = strings.Join(tags, ", ")
+
+	return values
+}
+
+// relatedNames returns the names of the expanded relations of a record
+func relatedNames(record *core.Record, field string) []string {
+	var names []string
+	for _, related := range record.ExpandedAll(field) {
+		names = append(names, related.GetString("name"))
+	}
+	return names
+}
+
+// splitSetTitle splits the YYMMDD date prefix fillSetRecord puts in front of set titles off the title
+func splitSetTitle(title string) (string, string) {
+	date, rest, ok := strings.Cut(title, " ")
+	if !ok || len(date) != 6 {
+		return "", title
+	}
+	if _, err := time.Parse("060102", date); err != nil {
+		return "", title
+	}
+	return date, rest
+}
+
+// editUpload validates the values of the edit modal like /upload does and applies them.
+// Editing a set applies the values to all of its items too, like editing the post of a set does.
+func editUpload(record *core.Record, values map[string]string) error {
+	if err := validateUploadDate(values["date"]); err != nil {
+		return err
+	}
+	metadata, err := buildUploadMetadata(values)
+	if err != nil {
+		return err
+	}
+	metadata.Date = values["date"]
+
+	metadataMap := metadata.metadataFields()
+	editable := make(map[string]string, len(uploadEditFields))
+	for _, key := range uploadEditFields {
+		editable[key] = metadataMap[key]
+	}
+
+	if uploadKind(record) == "item" {
+		applyMetadataMap(record, editable)
+		if err := App.Save(record); err != nil {
+			log.Printf("myuploads: unable to save item %s: %v", record.Id, err)
+			return fmt.Errorf("Could not save your changes.")
+		}
+		return nil
+	}
+
+	err = App.RunInTransaction(func(txApp core.App) error {
+		items, err := txApp.FindRecordsByFilter("contents", "set = {:set} && deleted = ''", "", 0, 0, dbx.Params{"set": record.Id})
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			applyMetadataMap(item, editable)
+			if err := txApp.Save(item); err != nil {
+				return err
+			}
+		}
+
+		fillSetRecord(record, metadata, record.GetDateTime("created").Time())
+		return txApp.Save(record)
+	})
+	if err != nil {
+		log.Printf("myuploads: unable to save set %s: %v", record.Id, err)
+		return fmt.Errorf("Could not save your changes.")
+	}
+	return nil
+}
+
+// editUploadSource replaces the source link of an item
+func editUploadSource(record *core.Record, source string) error {
+	if err := validateSourceLink(source); err != nil {
+		return err
+	}
+
+	record.Set("source", source)
+	if err := App.Save(record); err != nil {
+		log.Printf("myuploads: unable to save item %s: %v", record.Id, err)
+		return fmt.Errorf("Could not save your changes.")
+	}
+	return nil
+}
+
+// deleteUpload soft deletes an upload like the delete policy of guilds does, so curators can restore it.
+// Sets take their items with them, sets left without items are deleted as well.
+func deleteUpload(record *core.Record) error {
+	now := types.NowDateTime()
+
+	if uploadKind(record) == "item" {
+		record.Set("deleted", now)
+		if err := App.Save(record); err != nil {
+			return err
+		}
+		if setID := record.GetString("set"); setID != "" {
+			softDeleteSetIfEmpty(setID)
+		}
+		return nil
+	}
+
+	return App.RunInTransaction(func(txApp core.App) error {
+		items, err := txApp.FindRecordsByFilter("contents", "set = {:set} && deleted = ''", "", 0, 0, dbx.Params{"set": record.Id})
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			item.Set("deleted", now)
+			if err := txApp.Save(item); err != nil {
+				return err
+			}
+		}
+
+		record.Set("deleted", now)
+		return txApp.Save(record)
+	})
+}
+
+// truncateRunes cuts a value to at most n characters
+func truncateRunes(value string, n int) string {
+	runes := []rune(value)
+	if len(runes) <= n {
+		return value
+	}
+	return string(runes[:n-1]) + "…"
+}
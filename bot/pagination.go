@@ -0,0 +1,481 @@
+package bot
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"kcat-v3-be/bot/utils"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// paginationPrefix prefixes the CustomIDs of paginator controls, followed by "<session key>:<action>",
+// so the controls of every paginated message are namespaced to its own session
+const paginationPrefix = "page:"
+
+// paginationSessionTTL is how long the buttons of a paginated message keep working after their last use
+const paginationSessionTTL = 7 * 24 * time.Hour
+
+// maxJumpOptions is how many pages the jump menu lists, Discord allows 25 options in a select menu
+const maxJumpOptions = 25
+
+// errNoPages is returned when a paginator has nothing to show
+var errNoPages = errors.New("nothing to paginate")
+
+// pageLoader renders a page (0 based) of a lazily loaded paginator from the params it was sent with.
+// It also returns the current page count, so items added or removed since are picked up while paging.
+type pageLoader func(params json.RawMessage, page int) (string, int, error)
+
+// pageLoaders are the lazily loaded paginators by kind, the kind is stored with each session
+var pageLoaders = map[string]pageLoader{
+	"search": loadSearchPage,
+	"unwrap": loadUnwrapPage,
+}
+
+// PaginationState is a paginated message, its pages are loaded one at a time by the pageLoaders entry of Kind from Params
+type PaginationState struct {
+	Key       string                    `json:"sessionKey"`
+	Kind      string                    `json:"kind"`
+	Params    json.RawMessage           `json:"params"`
+	Page      int                       `json:"page"`
+	PageCount int                       `json:"pageCount"`
+	Embeds    []*discordgo.MessageEmbed `json:"embeds"`
+	// Owner is the only user who can change pages, unless Shared is set
+	Owner     string `json:"owner"`
+	Shared    bool   `json:"shared"`
+	ChannelID string `json:"channelId"`
+	MessageID string `json:"messageId"`
+}
+
+// newLazyPagination creates a paginator whose pages are loaded by the pageLoaders entry of kind
+func newLazyPagination(kind string, params any) (*PaginationState, error) {
+	if _, ok := pageLoaders[kind]; !ok {
+		return nil, fmt.Errorf("unknown paginator kind %q", kind)
+	}
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	return &PaginationState{Kind: kind, Params: raw}, nil
+}
+
+// render returns the content of the current page and updates the page count,
+// falling back to the last page when the current one no longer exists
+func (state *PaginationState) render() (string, error) {
+	loader, ok := pageLoaders[state.Kind]
+	if !ok {
+		return "", fmt.Errorf("unknown paginator kind %q", state.Kind)
+	}
+
+	state.Page = max(0, state.Page)
+	content, pageCount, err := loader(state.Params, state.Page)
+	if err != nil {
+		return "", err
+	}
+	if pageCount == 0 {
+		return "", errNoPages
+	}
+	if state.Page >= pageCount {
+		state.Page = pageCount - 1
+		content, pageCount, err = loader(state.Params, state.Page)
+		if err != nil {
+			return "", err
+		}
+	}
+	state.PageCount = pageCount
+
+	return content, nil
+}
+
+// content adds the page indicator to the content of the current page
+func (state *PaginationState) content(page string) string {
+	if state.PageCount <= 1 {
+		return page
+	}
+	return fmt.Sprintf("**Page %d / %d**\n\n%s", state.Page+1, state.PageCount, page)
+}
+
+// controls returns the buttons and the jump menu of the paginator, nothing when there is a single page
+func (state *PaginationState) controls(disabled bool) []discordgo.MessageComponent {
+	if state.PageCount <= 1 {
+		return []discordgo.MessageComponent{}
+	}
+
+	id := func(action string) string {
+		return paginationPrefix + state.Key + ":" + action
+	}
+	atStart := disabled || state.Page == 0
+	atEnd := disabled || state.Page >= state.PageCount-1
+
+	// the jump menu lists the pages around the current one when there are too many
+	first := max(0, min(state.Page-maxJumpOptions/2, state.PageCount-maxJumpOptions))
+	last := min(state.PageCount, first+maxJumpOptions)
+	var options []discordgo.SelectMenuOption
+	for page := first; page < last; page++ {
+		options = append(options, discordgo.SelectMenuOption{
+			Label:   fmt.Sprintf("Page %d", page+1),
+			Value:   strconv.Itoa(page),
+			Default: page == state.Page,
+		})
+	}
+
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{CustomID: id("first"), Emoji: &discordgo.ComponentEmoji{Name: "⏮️"}, Style: discordgo.PrimaryButton, Disabled: atStart},
+				discordgo.Button{CustomID: id("prev"), Emoji: &discordgo.ComponentEmoji{Name: "⬅️"}, Style: discordgo.PrimaryButton, Disabled: atStart},
+				discordgo.Button{CustomID: id("next"), Emoji: &discordgo.ComponentEmoji{Name: "➡️"}, Style: discordgo.PrimaryButton, Disabled: atEnd},
+				discordgo.Button{CustomID: id("last"), Emoji: &discordgo.ComponentEmoji{Name: "⏭️"}, Style: discordgo.PrimaryButton, Disabled: atEnd},
+			},
+		},
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.SelectMenu{
+					MenuType:    discordgo.StringSelectMenu,
+					CustomID:    id("jump"),
+					Placeholder: fmt.Sprintf("Jump to page (1-%d)", state.PageCount),
+					Options:     options,
+					Disabled:    disabled,
+				},
+			},
+		},
+	}
+}
+
+// sendPaginatedResponse replies to a command with the first page of a paginator owned by its user.
+// Nothing is sent when the page can't be rendered, errNoPages means there is nothing to show.
+func sendPaginatedResponse(s *discordgo.Session, ic *discordgo.InteractionCreate, state *PaginationState) error {
+	page, err := state.render()
+	if err != nil {
+		return err
+	}
+
+	state.Key = utils.GenerateRandomString(15)
+	state.Owner = getUserID(ic)
+
+	// the session is saved before the controls are sent, so they work as soon as they show up.
+	// A single page has no controls, so there is nothing to keep.
+	controls := state.controls(false)
+	if state.PageCount > 1 {
+		if err := savePaginationState(state); err != nil {
+			log.Printf("pagination: unable to save state: %v", err)
+			controls = []discordgo.MessageComponent{}
+		}
+	}
+
+	err = s.InteractionRespond(ic.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content:    state.content(page),
+			Embeds:     state.Embeds,
+			Components: controls,
+		},
+	})
+	if err != nil {
+		log.Printf("pagination: initial response failed: %v", err)
+		return nil
+	}
+
+	if len(controls) == 0 {
+		return nil
+	}
+
+	// the message is kept so its controls can be disabled once the session expires
+	msg, err := s.InteractionResponse(ic.Interaction)
+	if err != nil {
+		log.Printf("pagination: unable to get the response message: %v", err)
+		return nil
+	}
+	state.ChannelID = msg.ChannelID
+	state.MessageID = msg.ID
+
+	if err := savePaginationState(state); err != nil {
+		log.Printf("pagination: unable to save state: %v", err)
+	}
+	return nil
+}
+
+// handlePaginationInteraction changes the page of a paginated message
+func handlePaginationInteraction(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	data := i.MessageComponentData()
+
+	// messages from before the controls were namespaced have no session key and are treated as expired
+	key, action, _ := strings.Cut(strings.TrimPrefix(data.CustomID, paginationPrefix), ":")
+
+	state, err := loadPaginationState(key)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("pagination: unable to load state: %v", err)
+		}
+		respondWithExpiredControls(s, i)
+		return
+	}
+
+	if !state.Shared && getUserID(i) != state.Owner {
+		respondEphemeral(s, i.Interaction, "Only the user who ran the command can change pages.")
+		return
+	}
+
+	switch action {
+	case "first":
+		state.Page = 0
+	case "prev":
+		state.Page--
+	case "next":
+		state.Page++
+	case "last":
+		state.Page = state.PageCount - 1
+	case "jump":
+		if len(data.Values) > 0 {
+			if page, err := strconv.Atoi(data.Values[0]); err == nil {
+				state.Page = page
+			}
+		}
+	}
+
+	page, err := state.render()
+	if err != nil {
+		if errors.Is(err, errNoPages) {
+			respondEphemeral(s, i.Interaction, "There is nothing left to show.")
+			return
+		}
+		log.Printf("pagination: unable to render page: %v", err)
+		respondEphemeral(s, i.Interaction, "Could not load that page.")
+		return
+	}
+
+	if err := savePaginationState(state); err != nil {
+		log.Printf("pagination: unable to save state: %v", err)
+	}
+
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content:    state.content(page),
+			Components: state.controls(false),
+			Embeds:     state.Embeds,
+		},
+	})
+	if err != nil {
+		log.Printf("Error updating pagination message: %v", err)
+	}
+}
+
+// respondWithExpiredControls disables the controls of a paginated message whose session is gone
+func respondWithExpiredControls(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	components := i.Message.Components
+	disableControls(components)
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content:    i.Message.Content,
+			Embeds:     i.Message.Embeds,
+			Components: components,
+		},
+	})
+	if err != nil {
+		log.Printf("Error disabling expired pagination controls: %v", err)
+		return
+	}
+
+	_, err = s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+		Content: "These buttons have expired, run the command again.",
+		Flags:   discordgo.MessageFlagsEphemeral,
+	})
+	if err != nil {
+		log.Printf("Error sending pagination expiry notice: %v", err)
+	}
+}
+
+// disableControls disables the buttons and menus of components received from Discord
+func disableControls(components []discordgo.MessageComponent) {
+	for _, component := range components {
+		switch c := component.(type) {
+		case *discordgo.ActionsRow:
+			disableControls(c.Components)
+		case *discordgo.Button:
+			// link buttons, like the one to the site, keep working
+			if c.Style != discordgo.LinkButton {
+				c.Disabled = true
+			}
+		case *discordgo.SelectMenu:
+			c.Disabled = true
+		}
+	}
+}
+
+// savePaginationState stores the state of a paginated message in "pagination_sessions",
+// so the controls keep working across restarts and on every bot replica
+func savePaginationState(state *PaginationState) error {
+	record, err := App.FindFirstRecordByFilter("pagination_sessions", "sessionKey = {:key}", dbx.Params{"key": state.Key})
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		collection, err := App.FindCollectionByNameOrId("pagination_sessions")
+		if err != nil {
+			return err
+		}
+		record = core.NewRecord(collection)
+		record.Set("sessionKey", state.Key)
+	}
+
+	expires, err := types.ParseDateTime(time.Now().Add(paginationSessionTTL))
+	if err != nil {
+		return err
+	}
+
+	record.Set("kind", state.Kind)
+	record.Set("params", state.Params)
+	record.Set("page", state.Page)
+	record.Set("pageCount", state.PageCount)
+	record.Set("embeds", state.Embeds)
+	record.Set("owner", state.Owner)
+	record.Set("shared", state.Shared)
+	record.Set("channelId", state.ChannelID)
+	record.Set("messageId", state.MessageID)
+	record.Set("expires", expires)
+
+	return App.Save(record)
+}
+
+// loadPaginationState returns the state of a paginated message, sql.ErrNoRows when it is unknown or expired
+func loadPaginationState(key string) (*PaginationState, error) {
+	if key == "" {
+		return nil, sql.ErrNoRows
+	}
+
+	record, err := App.FindFirstRecordByFilter(
+		"pagination_sessions",
+		"sessionKey = {:key} && expires > {:now}",
+		dbx.Params{"key": key, "now": types.NowDateTime().String()},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return paginationStateFromRecord(record)
+}
+
+// paginationStateFromRecord reads a "pagination_sessions" record
+func paginationStateFromRecord(record *core.Record) (*PaginationState, error) {
+	state := &PaginationState{
+		Key:       record.GetString("sessionKey"),
+		Kind:      record.GetString("kind"),
+		Page:      record.GetInt("page"),
+		PageCount: record.GetInt("pageCount"),
+		Owner:     record.GetString("owner"),
+		Shared:    record.GetBool("shared"),
+		ChannelID: record.GetString("channelId"),
+		MessageID: record.GetString("messageId"),
+	}
+
+	if err := record.UnmarshalJSONField("params", &state.Params); err != nil {
+		return nil, err
+	}
+	if err := record.UnmarshalJSONField("embeds", &state.Embeds); err != nil {
+		return nil, err
+	}
+
+	// sessions from before every paginator was loaded by kind are unusable
+	if state.Kind == "" {
+		return nil, sql.ErrNoRows
+	}
+
+	return state, nil
+}
+
+// contentsPage renders a page of a query on "contents", newest first, with one entry per record.
+// Records without an entry are skipped, so a page can show less than perPage entries.
+func contentsPage(q *contentsQuery, perPage, page int, separator string, entry func(*core.Record) string) (string, int, error) {
+	total, err := q.count()
+	if err != nil {
+		return "", 0, err
+	}
+
+	pageCount := (total + perPage - 1) / perPage
+	if page >= pageCount {
+		return "", pageCount, nil
+	}
+
+	records, err := q.findPage("-created", perPage, page*perPage)
+	if err != nil {
+		return "", 0, err
+	}
+
+	var entries []string
+	for _, record := range records {
+		if e := entry(record); e != "" {
+			entries = append(entries, e)
+		}
+	}
+	if len(entries) == 0 {
+		return "_Nothing to show on this page._", pageCount, nil
+	}
+
+	return strings.Join(entries, separator), pageCount, nil
+}
+
+// cleanupExpiredPaginationSessions disables the controls of the paginated messages nobody used
+// within their TTL and deletes their sessions
+func cleanupExpiredPaginationSessions(s *discordgo.Session) {
+	records, err := App.FindRecordsByFilter(
+		"pagination_sessions",
+		"expires <= {:now}",
+		"",
+		0,
+		0,
+		dbx.Params{"now": types.NowDateTime().String()},
+	)
+	if err != nil {
+		slog.Error("UNABLE TO FIND EXPIRED PAGINATION SESSIONS", "MSG", err)
+		return
+	}
+
+	for _, record := range records {
+		if state, err := paginationStateFromRecord(record); err == nil && state.MessageID != "" {
+			components := state.controls(true)
+			edit := discordgo.NewMessageEdit(state.ChannelID, state.MessageID)
+			edit.Components = &components
+			// the message may be deleted by now, the session goes either way
+			if _, err := s.ChannelMessageEditComplex(edit); err != nil {
+				slog.Warn("UNABLE TO DISABLE EXPIRED PAGINATION CONTROLS", "MSG", err, "message", state.MessageID)
+			}
+		}
+
+		if err := App.Delete(record); err != nil {
+			slog.Error("UNABLE TO DELETE PAGINATION SESSION", "MSG", err)
+		}
+	}
+
+	if len(records) > 0 {
+		slog.Info("Cleaned up expired pagination sessions", "deleted", len(records))
+	}
+}
+
+// paginationCleanupJob is the cron job ID of the pagination cleanup
+const paginationCleanupJob = "paginationSessionsCleanup"
+
+// registerPaginationCleanup runs cleanupExpiredPaginationSessions every hour with the session of s,
+// until unregisterPaginationCleanup removes it
+func registerPaginationCleanup(s *discordgo.Session) {
+	App.Cron().MustAdd(paginationCleanupJob, "0 * * * *", func() {
+		cleanupExpiredPaginationSessions(s)
+	})
+}
+
+// unregisterPaginationCleanup removes the cleanup job, so a closed session is never used again
+func unregisterPaginationCleanup() {
+	App.Cron().Remove(paginationCleanupJob)
+}
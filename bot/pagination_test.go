@@ -0,0 +1,30 @@
+package bot
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestPaginationCleanupIsRemoved(t *testing.T) {
+	app := newTestApp(t)
+
+	hasJob := func() bool {
+		for _, job := range app.Cron().Jobs() {
+			if job.Id() == paginationCleanupJob {
+				return true
+			}
+		}
+		return false
+	}
+
+	registerPaginationCleanup(&discordgo.Session{})
+	if !hasJob() {
+		t.Fatal("cleanup job not registered")
+	}
+
+	unregisterPaginationCleanup()
+	if hasJob() {
+		t.Error("cleanup job still registered, it would keep using the closed session")
+	}
+}
@@ -0,0 +1,165 @@
+package bot
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// allCommands is the command of permissions that apply to every command of a guild
+const allCommands = "*"
+
+// loadCommandPermissions reads the permissions of a guild that apply to a command, every command when it's empty
+func loadCommandPermissions(guildID, command string) ([]CommandPermission, error) {
+	filter := "guildId = {:guildId}"
+	params := dbx.Params{"guildId": guildID, "all": allCommands}
+	if command != "" {
+		filter += " && (command = {:command} || command = {:all})"
+		params["command"] = command
+	}
+
+	records, err := App.FindRecordsByFilter("discord_command_permissions", filter, "", 0, 0, params)
+	if err != nil {
+		return nil, err
+	}
+
+	var permissions []CommandPermission
+	for _, record := range records {
+		permission := CommandPermission{
+			GuildID:     record.GetString("guildId"),
+			Command:     record.GetString("command"),
+			Permissions: int64(record.GetInt("permissions")),
+		}
+		for field, target := range map[string]*[]string{
+			"roles":      &permission.Roles,
+			"allowUsers": &permission.AllowUsers,
+			"denyUsers":  &permission.DenyUsers,
+		} {
+			if err := record.UnmarshalJSONField(field, target); err != nil {
+				slog.Warn("INVALID "+field+" IN COMMAND PERMISSIONS", "GUILD", guildID, "COMMAND", permission.Command, "MSG", err)
+			}
+		}
+		permissions = append(permissions, permission)
+	}
+
+	return permissions, nil
+}
+
+// commandDenial returns why the user of an interaction can't use a command, "" when they can.
+// Denied users are always refused, allowed users skip the role and permission requirements.
+// Everyone else needs one of the roles and all the permissions of every matching entry.
+// DMs have no guild permissions, Discord only offers them the commands allowed there.
+func commandDenial(i *discordgo.InteractionCreate, command string) (string, error) {
+	if i.GuildID == "" || i.Member == nil {
+		return "", nil
+	}
+
+	permissions, err := loadCommandPermissions(i.GuildID, command)
+	if err != nil {
+		return "", err
+	}
+
+	userID := getUserID(i)
+	for _, p := range permissions {
+		if slices.Contains(p.DenyUsers, userID) {
+			return "You are not allowed to use this command.", nil
+		}
+	}
+	for _, p := range permissions {
+		if slices.Contains(p.AllowUsers, userID) {
+			return "", nil
+		}
+	}
+
+	for _, p := range permissions {
+		if len(p.Roles) > 0 && !slices.ContainsFunc(i.Member.Roles, func(role string) bool { return slices.Contains(p.Roles, role) }) {
+			var mentions []string
+			for _, role := range p.Roles {
+				mentions = append(mentions, "<@&"+role+">")
+			}
+			return fmt.Sprintf("You need one of these roles to use this command: %s", strings.Join(mentions, ", ")), nil
+		}
+		if p.Permissions != 0 && i.Member.Permissions&p.Permissions != p.Permissions &&
+			i.Member.Permissions&discordgo.PermissionAdministrator == 0 {
+			return "You don't have the permissions this command requires.", nil
+		}
+	}
+
+	return "", nil
+}
+
+// guildCommands returns the commands of a guild with the permissions required there as their
+// DefaultMemberPermissions, so Discord hides them from members who can't use them anyway.
+// Entries with allowed users are left out, those users may lack the permissions.
+// Required roles can only be enforced by the bot, Discord needs a user token to restrict commands to roles.
+func guildCommands(guildID string, commands []*discordgo.ApplicationCommand) []*discordgo.ApplicationCommand {
+	permissions, err := loadCommandPermissions(guildID, "")
+	if err != nil {
+		slog.Error("UNABLE TO LOAD COMMAND PERMISSIONS", "GUILD", guildID, "MSG", err)
+		return commands
+	}
+
+	required := make(map[string]int64)
+	for _, p := range permissions {
+		if p.Permissions == 0 || len(p.AllowUsers) > 0 {
+			continue
+		}
+		for _, cmd := range commands {
+			if p.Command == allCommands || p.Command == cmd.Name {
+				required[cmd.Name] |= p.Permissions
+			}
+		}
+	}
+
+	scoped := make([]*discordgo.ApplicationCommand, 0, len(commands))
+	for _, cmd := range commands {
+		bits, ok := required[cmd.Name]
+		if !ok {
+			scoped = append(scoped, cmd)
+			continue
+		}
+		if cmd.DefaultMemberPermissions != nil {
+			bits |= *cmd.DefaultMemberPermissions
+		}
+		scopedCmd := *cmd
+		scopedCmd.DefaultMemberPermissions = &bits
+		scoped = append(scoped, &scopedCmd)
+	}
+
+	return scoped
+}
+
+// permissionHooksOnce binds the permission hooks on the first Start only, hooks stay bound across restarts of the bot
+var permissionHooksOnce sync.Once
+
+// registerCommandPermissionHooks registers the commands of a guild again whenever its permissions change
+func registerCommandPermissionHooks() {
+	permissionHooksOnce.Do(bindCommandPermissionHooks)
+}
+
+// bindCommandPermissionHooks binds the hooks of registerCommandPermissionHooks
+func bindCommandPermissionHooks() {
+	resync := func(e *core.RecordEvent) error {
+		guilds := []string{e.Record.GetString("guildId")}
+		// an entry moved to another guild no longer applies to its previous one
+		if previous := e.Record.Original().GetString("guildId"); previous != "" && previous != guilds[0] {
+			guilds = append(guilds, previous)
+		}
+		go func() {
+			for _, guildID := range guilds {
+				resyncGuildCommands(guildID)
+			}
+		}()
+		return e.Next()
+	}
+
+	App.OnRecordAfterCreateSuccess("discord_command_permissions").BindFunc(resync)
+	App.OnRecordAfterUpdateSuccess("discord_command_permissions").BindFunc(resync)
+	App.OnRecordAfterDeleteSuccess("discord_command_permissions").BindFunc(resync)
+}
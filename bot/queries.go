@@ -0,0 +1,146 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/search"
+)
+
+// errFilterBackslash is returned for filter values with a backslash. Pocketbase quotes bound values
+// but its filter parser only understands escaped quotes, so a trailing backslash would swallow the
+// closing quote and let the next value be parsed as part of the filter.
+var errFilterBackslash = errors.New("filter values can't contain backslashes")
+
+// contentsQuery builds filters on "contents" with every value bound as a parameter,
+// so user input never ends up in the filter string itself. Deleted records are always excluded.
+type contentsQuery struct {
+	conditions []string
+	params     dbx.Params
+	err        error
+}
+
+func newContentsQuery() *contentsQuery {
+	return &contentsQuery{
+		conditions: []string{"deleted = ''"},
+		params:     dbx.Params{},
+	}
+}
+
+// bind stores a value as a parameter and returns its placeholder
+func (q *contentsQuery) bind(value any) string {
+	if s, ok := value.(string); ok && strings.Contains(s, `\`) {
+		q.err = errFilterBackslash
+	}
+
+	key := fmt.Sprintf("p%d", len(q.params))
+	q.params[key] = value
+	return "{:" + key + "}"
+}
+
+// where adds a condition that holds no user input, like "isQuality = true"
+func (q *contentsQuery) where(condition string) *contentsQuery {
+	q.conditions = append(q.conditions, condition)
+	return q
+}
+
+// equals matches a single value field, field must be a known field name and never user input
+func (q *contentsQuery) equals(field string, value any) *contentsQuery {
+	return q.where(field + " = " + q.bind(value))
+}
+
+// anyOf matches a multi value relation holding any of the IDs
+func (q *contentsQuery) anyOf(field string, ids ...string) *contentsQuery {
+	var conditions []string
+	for _, id := range ids {
+		conditions = append(conditions, field+":each ?= "+q.bind(id))
+	}
+	if len(conditions) == 0 {
+		return q
+	}
+	return q.where("(" + strings.Join(conditions, " || ") + ")")
+}
+
+func (q *contentsQuery) mirror(link string) *contentsQuery {
+	return q.equals("mirror", link)
+}
+
+func (q *contentsQuery) inSet(setID string) *contentsQuery {
+	return q.equals("set", setID)
+}
+
+func (q *contentsQuery) inCollection(collectionID string) *contentsQuery {
+	return q.anyOf("collections", collectionID)
+}
+
+func (q *contentsQuery) withIdol(idolIDs ...string) *contentsQuery {
+	return q.anyOf("idol", idolIDs...)
+}
+
+func (q *contentsQuery) withGroup(groupIDs ...string) *contentsQuery {
+	return q.anyOf("group", groupIDs...)
+}
+
+func (q *contentsQuery) withTag(tagIDs ...string) *contentsQuery {
+	return q.anyOf("tag", tagIDs...)
+}
+
+func (q *contentsQuery) withUploader(uploaderIDs ...string) *contentsQuery {
+	return q.anyOf("uploader", uploaderIDs...)
+}
+
+// isFiltered reports whether anything narrows the query down besides excluding deleted records
+func (q *contentsQuery) isFiltered() bool {
+	return len(q.conditions) > 1
+}
+
+// filter returns the filter string and its parameters
+func (q *contentsQuery) filter() (string, dbx.Params) {
+	return strings.Join(q.conditions, " && "), q.params
+}
+
+// find returns the matching records, a limit of 0 returns all of them
+func (q *contentsQuery) find(sort string, limit int) ([]*core.Record, error) {
+	return q.findPage(sort, limit, 0)
+}
+
+// findPage returns the matching records after skipping offset of them
+func (q *contentsQuery) findPage(sort string, limit, offset int) ([]*core.Record, error) {
+	if q.err != nil {
+		return nil, q.err
+	}
+	filter, params := q.filter()
+	return App.FindRecordsByFilter("contents", filter, sort, limit, offset, params)
+}
+
+// count returns how many records match without loading them
+func (q *contentsQuery) count() (int, error) {
+	if q.err != nil {
+		return 0, q.err
+	}
+
+	collection, err := App.FindCollectionByNameOrId("contents")
+	if err != nil {
+		return 0, err
+	}
+
+	// the same resolver FindRecordsByFilter uses, so ":each" conditions get their joins
+	resolver := core.NewRecordFieldResolver(App, collection, nil, true)
+	filter, params := q.filter()
+	expr, err := search.FilterData(filter).BuildExpr(resolver, params)
+	if err != nil {
+		return 0, err
+	}
+
+	query := App.DB().Select("COUNT(DISTINCT [[contents.id]])").From("contents").AndWhere(expr)
+	if err := resolver.UpdateQuery(query); err != nil {
+		return 0, err
+	}
+
+	var total int
+	err = query.Row(&total)
+	return total, err
+}
@@ -0,0 +1,142 @@
+package bot
+
+import (
+	"errors"
+	"maps"
+	"testing"
+
+	"github.com/pocketbase/dbx"
+)
+
+func TestContentsQueryFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  *contentsQuery
+		filter string
+		params dbx.Params
+	}{
+		{
+			name:   "nothing but deleted records excluded",
+			query:  newContentsQuery(),
+			filter: "deleted = ''",
+			params: dbx.Params{},
+		},
+		{
+			name:   "quotes are bound",
+			query:  newContentsQuery().mirror(`https://imgur.com/a"b'c`),
+			filter: "deleted = '' && mirror = {:p0}",
+			params: dbx.Params{"p0": `https://imgur.com/a"b'c`},
+		},
+		{
+			name:   "placeholder lookalikes stay values",
+			query:  newContentsQuery().inSet("{:p0}").equals("title", "{:p1}"),
+			filter: "deleted = '' && set = {:p0} && title = {:p1}",
+			params: dbx.Params{"p0": "{:p0}", "p1": "{:p1}"},
+		},
+		{
+			name:   "or injection is bound",
+			query:  newContentsQuery().equals("title", `x' || deleted != '`),
+			filter: "deleted = '' && title = {:p0}",
+			params: dbx.Params{"p0": `x' || deleted != '`},
+		},
+		{
+			name:   "and injection is bound",
+			query:  newContentsQuery().withTag(`x" && isQuality = true && tag != "`),
+			filter: "deleted = '' && (tag:each ?= {:p0})",
+			params: dbx.Params{"p0": `x" && isQuality = true && tag != "`},
+		},
+		{
+			name:   "any of several relations",
+			query:  newContentsQuery().withGroup("g1", "g2").withIdol("i1").where("isQuality = true"),
+			filter: "deleted = '' && (group:each ?= {:p0} || group:each ?= {:p1}) && (idol:each ?= {:p2}) && isQuality = true",
+			params: dbx.Params{"p0": "g1", "p1": "g2", "p2": "i1"},
+		},
+		{
+			name:   "no relations adds no condition",
+			query:  newContentsQuery().withUploader(),
+			filter: "deleted = ''",
+			params: dbx.Params{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.query.err != nil {
+				t.Fatalf("unexpected error: %v", tt.query.err)
+			}
+			filter, params := tt.query.filter()
+			if filter != tt.filter {
+				t.Errorf("filter = %q, want %q", filter, tt.filter)
+			}
+			if !maps.Equal(params, tt.params) {
+				t.Errorf("params = %v, want %v", params, tt.params)
+			}
+		})
+	}
+}
+
+func TestContentsQueryRejectsBackslashes(t *testing.T) {
+	tests := []struct {
+		name  string
+		query *contentsQuery
+	}{
+		{"trailing backslash", newContentsQuery().equals("title", `x\`)},
+		{"escaped quote", newContentsQuery().mirror(`x\' || deleted != '`)},
+		{"relation", newContentsQuery().withIdol("i1", `i2\`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the error is returned before the database is touched
+			if _, err := tt.query.find("", 0); !errors.Is(err, errFilterBackslash) {
+				t.Errorf("find error = %v, want %v", err, errFilterBackslash)
+			}
+			if _, err := tt.query.count(); !errors.Is(err, errFilterBackslash) {
+				t.Errorf("count error = %v, want %v", err, errFilterBackslash)
+			}
+		})
+	}
+}
+
+func TestContentsQueryMatchesValuesLiterally(t *testing.T) {
+	app := newTestApp(t)
+
+	tricky := `https://imgur.com/x' || deleted != '`
+	createTestRecord(t, app, "contents", map[string]any{"title": "plain", "mirror": "https://imgur.com/plain"})
+	match := createTestRecord(t, app, "contents", map[string]any{"title": "tricky", "mirror": tricky})
+	createTestRecord(t, app, "contents", map[string]any{"title": "{:p0}", "mirror": "https://imgur.com/deleted", "deleted": "2024-01-01 00:00:00.000Z"})
+
+	tests := []struct {
+		name  string
+		query *contentsQuery
+		want  []string
+	}{
+		{"injected value matches itself", newContentsQuery().mirror(tricky), []string{match.Id}},
+		{"placeholder lookalike matches nothing", newContentsQuery().equals("title", "{:p0}"), nil},
+		{"unknown relation matches nothing", newContentsQuery().withTag(`" || id != "`), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := tt.query.find("", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, record := range records {
+				ids = append(ids, record.Id)
+			}
+			if len(ids) != len(tt.want) || (len(ids) > 0 && ids[0] != tt.want[0]) {
+				t.Errorf("records = %v, want %v", ids, tt.want)
+			}
+
+			total, err := tt.query.count()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if total != len(tt.want) {
+				t.Errorf("count = %d, want %d", total, len(tt.want))
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package bot
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	"kcat-v3-be/bot/utils"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// randomRerollPrefix prefixes the CustomID of the reroll button, followed by the encoded filters
+const randomRerollPrefix = "random:"
+
+// randomFilterOptions are the /random options that narrow down the pick
+var randomFilterOptions = []string{"idol", "group", "tag", "filetype", "quality"}
+
+var randomCommand = &discordgo.ApplicationCommand{
+	Name:        "random",
+	Description: "Show a random item from the KpopCat archive.",
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Name:         "idol",
+			Description:  "Idol name",
+			Type:         discordgo.ApplicationCommandOptionString,
+			Autocomplete: true,
+		},
+		{
+			Name:         "group",
+			Description:  "Group name",
+			Type:         discordgo.ApplicationCommandOptionString,
+			Autocomplete: true,
+		},
+		{
+			Name:         "tag",
+			Description:  "Tag name",
+			Type:         discordgo.ApplicationCommandOptionString,
+			Autocomplete: true,
+		},
+		{
+			Name:        "filetype",
+			Description: "Only videos or only images",
+			Type:        discordgo.ApplicationCommandOptionString,
+			Choices: []*discordgo.ApplicationCommandOptionChoice{
+				{Name: "video", Value: "video"},
+				{Name: "image", Value: "image"},
+			},
+		},
+		{
+			Name:        "quality",
+			Description: "Only items marked as quality",
+			Type:        discordgo.ApplicationCommandOptionBoolean,
+		},
+	},
+}
+
+func handleRandomCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	options := make(map[string]string)
+	for _, opt := range i.ApplicationCommandData().Options {
+		switch opt.Type {
+		case discordgo.ApplicationCommandOptionBoolean:
+			if opt.BoolValue() {
+				options[opt.Name] = "true"
+			}
+		default:
+			options[opt.Name] = strings.TrimSpace(opt.StringValue())
+		}
+	}
+
+	content, embeds, components, err := buildRandomResponse(options)
+	if err != nil {
+		respondWithError(s, i.Interaction, err.Error())
+		return
+	}
+
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content:    content,
+			Embeds:     embeds,
+			Components: components,
+		},
+	})
+	if err != nil {
+		log.Printf("Error responding to /random: %v", err)
+	}
+}
+
+// handleRandomReroll replaces a /random response with another pick using the same filters
+func handleRandomReroll(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	// rerolls count as /random uses
+	if ok, msg := allowCommand(getUserID(i), i.GuildID, "random"); !ok {
+		respondEphemeral(s, i.Interaction, msg)
+		return
+	}
+
+	values, err := url.ParseQuery(strings.TrimPrefix(i.MessageComponentData().CustomID, randomRerollPrefix))
+	if err != nil {
+		respondWithError(s, i.Interaction, "Could not read the filters of this message.")
+		return
+	}
+
+	options := make(map[string]string)
+	for _, name := range randomFilterOptions {
+		if value := values.Get(name); value != "" {
+			options[name] = value
+		}
+	}
+
+	content, embeds, components, err := buildRandomResponse(options)
+	if err != nil {
+		respondWithError(s, i.Interaction, err.Error())
+		return
+	}
+
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content:    content,
+			Embeds:     embeds,
+			Components: components,
+		},
+	})
+	if err != nil {
+		log.Printf("Error rerolling /random: %v", err)
+	}
+}
+
+// buildRandomResponse picks a random item matching the options and renders it with a reroll button
+func buildRandomResponse(options map[string]string) (string, []*discordgo.MessageEmbed, []discordgo.MessageComponent, error) {
+	q, err := buildContentsQuery(options)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	switch filetype := options["filetype"]; filetype {
+	case "":
+	case "video", "image":
+		q.equals("filetype", filetype)
+	default:
+		return "", nil, nil, fmt.Errorf("Unknown filetype: %s", filetype)
+	}
+
+	if options["quality"] == "true" {
+		q.where("isQuality = true")
+	}
+
+	record, err := findRandomRecord(q)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil, nil, fmt.Errorf("No items found for those filters.")
+		}
+		log.Printf("random: query failed: %v", err)
+		return "", nil, nil, fmt.Errorf("Could not query database.")
+	}
+
+	content, embeds, components := contentResponse(record)
+
+	if customID := randomRerollPrefix + encodeRandomOptions(options); len(customID) <= 100 {
+		components = append(components, discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{CustomID: customID, Label: "Reroll", Emoji: &discordgo.ComponentEmoji{Name: "🎲"}, Style: discordgo.PrimaryButton},
+			},
+		})
+	}
+
+	return content, embeds, components, nil
+}
+
+// encodeRandomOptions encodes the /random filters for the reroll button
+func encodeRandomOptions(options map[string]string) string {
+	values := url.Values{}
+	for _, name := range randomFilterOptions {
+		if value := options[name]; value != "" {
+			values.Set(name, value)
+		}
+	}
+	return values.Encode()
+}
+
+// findRandomRecord returns a random record matching the query without sorting the whole table.
+// Record IDs are random, so the first match at or after a random ID is a uniform enough pick,
+// wrapping around to the start when nothing comes after it.
+func findRandomRecord(q *contentsQuery) (*core.Record, error) {
+	if q.err != nil {
+		return nil, q.err
+	}
+	filter, params := q.filter()
+	params["pivot"] = utils.GenerateRandomString(15)
+
+	records, err := App.FindRecordsByFilter("contents", filter+" && id >= {:pivot}", "id", 1, 0, params)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) > 0 {
+		return records[0], nil
+	}
+
+	records, err = App.FindRecordsByFilter("contents", filter+" && id < {:pivot}", "id", 1, 0, params)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) > 0 {
+		return records[0], nil
+	}
+
+	return nil, sql.ErrNoRows
+}
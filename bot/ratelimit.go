@@ -0,0 +1,161 @@
+package bot
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimit allows Burst uses at once, refilled at Burst uses per Per. A zero Burst disables the limit.
+type rateLimit struct {
+	Burst int
+	Per   time.Duration
+}
+
+// tokenBucket is the remaining uses of a single key
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps a token bucket per key, like a user and a command
+type rateLimiter struct {
+	env     string
+	mu      sync.Mutex
+	limit   rateLimit
+	buckets map[string]*tokenBucket
+	pruned  time.Time
+}
+
+// The limits can be changed with "<uses>/<duration>" in their environment variable, like "5/1m",
+// or turned off with "off"
+var (
+	commandUserLimiter  = newRateLimiter("RATE_LIMIT_COMMAND_USER", rateLimit{Burst: 5, Per: time.Minute})
+	commandGuildLimiter = newRateLimiter("RATE_LIMIT_COMMAND_GUILD", rateLimit{Burst: 60, Per: time.Minute})
+	ingestUserLimiter   = newRateLimiter("RATE_LIMIT_INGEST_USER", rateLimit{Burst: 10, Per: time.Minute})
+	ingestGuildLimiter  = newRateLimiter("RATE_LIMIT_INGEST_GUILD", rateLimit{Burst: 60, Per: time.Minute})
+)
+
+func newRateLimiter(env string, limit rateLimit) *rateLimiter {
+	return &rateLimiter{env: env, limit: limit, buckets: make(map[string]*tokenBucket)}
+}
+
+// configureRateLimits reads the limits from the environment, invalid values keep the default
+func configureRateLimits() {
+	for _, limiter := range []*rateLimiter{commandUserLimiter, commandGuildLimiter, ingestUserLimiter, ingestGuildLimiter} {
+		value := os.Getenv(limiter.env)
+		if value == "" {
+			continue
+		}
+
+		limit, err := parseRateLimit(value)
+		if err != nil {
+			slog.Warn("INVALID RATE LIMIT, USING THE DEFAULT", "ENV", limiter.env, "MSG", err)
+			continue
+		}
+
+		limiter.mu.Lock()
+		limiter.limit = limit
+		limiter.buckets = make(map[string]*tokenBucket)
+		limiter.mu.Unlock()
+	}
+}
+
+// parseRateLimit parses "<uses>/<duration>" like "5/1m", "off" disables the limit
+func parseRateLimit(value string) (rateLimit, error) {
+	if strings.EqualFold(value, "off") {
+		return rateLimit{}, nil
+	}
+
+	uses, per, ok := strings.Cut(value, "/")
+	if !ok {
+		return rateLimit{}, fmt.Errorf("expected <uses>/<duration>, got %q", value)
+	}
+
+	burst, err := strconv.Atoi(strings.TrimSpace(uses))
+	if err != nil || burst < 0 {
+		return rateLimit{}, fmt.Errorf("invalid number of uses %q", uses)
+	}
+	duration, err := time.ParseDuration(strings.TrimSpace(per))
+	if err != nil || duration <= 0 {
+		return rateLimit{}, fmt.Errorf("invalid duration %q", per)
+	}
+
+	return rateLimit{Burst: burst, Per: duration}, nil
+}
+
+// allow takes a use from the bucket of key. When it's empty it returns false and how long until the next use.
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.limit.Burst <= 0 {
+		return true, 0
+	}
+
+	now := time.Now()
+	burst := float64(l.limit.Burst)
+	perSecond := burst / l.limit.Per.Seconds()
+
+	// full buckets are the same as no bucket, drop them once in a while so the map doesn't keep growing
+	if now.Sub(l.pruned) > l.limit.Per {
+		for k, b := range l.buckets {
+			if now.Sub(b.last) >= l.limit.Per {
+				delete(l.buckets, k)
+			}
+		}
+		l.pruned = now
+	}
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: burst, last: now}
+		l.buckets[key] = bucket
+	}
+
+	bucket.tokens = min(burst, bucket.tokens+now.Sub(bucket.last).Seconds()*perSecond)
+	bucket.last = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+
+	return false, time.Duration((1 - bucket.tokens) / perSecond * float64(time.Second))
+}
+
+// allowCommand checks the limits of a user and their guild for a command, the guild is empty in DMs.
+// It returns a message for the user when a limit is hit.
+func allowCommand(userID, guildID, command string) (bool, string) {
+	if ok, wait := commandUserLimiter.allow(userID + ":" + command); !ok {
+		return false, fmt.Sprintf("You're using /%s too fast, try again %s.", command, retryTimestamp(wait))
+	}
+	if guildID != "" {
+		if ok, wait := commandGuildLimiter.allow(guildID + ":" + command); !ok {
+			return false, fmt.Sprintf("/%s is used too much in this server right now, try again %s.", command, retryTimestamp(wait))
+		}
+	}
+	return true, ""
+}
+
+// allowIngest checks the limits of a user and their guild for ingesting messages
+func allowIngest(userID, guildID string) bool {
+	if ok, _ := ingestUserLimiter.allow(userID); !ok {
+		return false
+	}
+	if guildID != "" {
+		if ok, _ := ingestGuildLimiter.allow(guildID); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// retryTimestamp renders when a limit allows the next use, Discord shows it relative like "in 12 seconds"
+func retryTimestamp(wait time.Duration) string {
+	return fmt.Sprintf("<t:%d:R>", time.Now().Add(wait).Add(time.Second).Unix())
+}
@@ -0,0 +1,183 @@
+package bot
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Reaction actions that can be mapped to emojis in the guild config.
+// "tag:<name>" adds the tag <name>, creating it if needed.
+const (
+	ReactionActionQuality   = "quality"
+	ReactionActionReview    = "review"
+	ReactionActionDelete    = "delete"
+	ReactionActionTagPrefix = "tag:"
+)
+
+// messageReactionAdd lets curators moderate uploads by reacting to the upload post or to the bot reply about it
+func messageReactionAdd(s *discordgo.Session, r *discordgo.MessageReactionAdd) {
+	if r == nil || r.MessageReaction == nil || r.GuildID == "" {
+		return
+	}
+	if s.State.User != nil && r.UserID == s.State.User.ID {
+		return
+	}
+
+	config := loadGuildConfig(r.GuildID)
+	action, ok := config.reactionAction(r.Emoji)
+	if !ok {
+		return
+	}
+	// reactions from anyone else are just reactions
+	if !config.isCurator(r.Member) {
+		return
+	}
+
+	records, err := findRecordsForReaction(s, r.MessageReaction)
+	if err != nil {
+		slog.Error("UNABLE TO FIND RECORDS FOR REACTION", "MSG", err)
+		return
+	}
+	if len(records) == 0 {
+		return
+	}
+
+	if err := applyReactionAction(action, records); err != nil {
+		slog.Error("UNABLE TO APPLY REACTION ACTION", "ACTION", action, "MSG", err)
+		return
+	}
+
+	recordIDs := make([]string, 0, len(records))
+	for _, record := range records {
+		recordIDs = append(recordIDs, record.Id)
+	}
+
+	recordAudit(AuditEntry{
+		Action:    "reaction:" + action,
+		ActorID:   r.UserID,
+		GuildID:   r.GuildID,
+		ChannelID: r.ChannelID,
+		MessageID: r.MessageID,
+		Records:   recordIDs,
+		Details:   map[string]any{"emoji": r.Emoji.Name},
+	})
+
+	if err := s.MessageReactionAdd(r.ChannelID, r.MessageID, "✅"); err != nil {
+		slog.Warn("UNABLE TO CONFIRM REACTION ACTION", "MSG", err)
+	}
+}
+
+// reactionAction returns the action mapped to an emoji. Custom emojis can be mapped
+// by name or by "name:id", unicode emojis with or without the variation selector.
+func (c GuildConfig) reactionAction(emoji discordgo.Emoji) (string, bool) {
+	for key, action := range c.ReactionActions {
+		if key == emoji.APIName() || strings.TrimSuffix(key, "\ufe0f") == strings.TrimSuffix(emoji.Name, "\ufe0f") {
+			return action, true
+		}
+	}
+	return "", false
+}
+
+// applyReactionAction applies a moderation action to every record
+func applyReactionAction(action string, records []*core.Record) error {
+	var tagID string
+	if strings.HasPrefix(action, ReactionActionTagPrefix) {
+		id, err := lookupOrCreateTag(strings.TrimPrefix(action, ReactionActionTagPrefix))
+		if err != nil {
+			return err
+		}
+		tagID = id
+	}
+
+	setIDs := make(map[string]bool)
+	for _, record := range records {
+		switch {
+		case action == ReactionActionQuality:
+			record.Set("isQuality", true)
+		case action == ReactionActionReview:
+			record.Set("needsReview", true)
+		case action == ReactionActionDelete:
+			// curators delete the same way the delete policy does, so it can be undone from the admin UI
+			record.Set("deleted", types.NowDateTime())
+			if setID := record.GetString("set"); setID != "" {
+				setIDs[setID] = true
+			}
+		case tagID != "":
+			record.Set("tag+", tagID)
+		default:
+			return errors.New("unknown reaction action: " + action)
+		}
+
+		if err := App.Save(record); err != nil {
+			slog.Error("ERROR SAVING RECORD", "MSG", err)
+			return err
+		}
+	}
+
+	for setID := range setIDs {
+		softDeleteSetIfEmpty(setID)
+	}
+
+	return nil
+}
+
+// lookupOrCreateTag finds a tag by name or code, creating it when it doesn't exist yet
+func lookupOrCreateTag(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	code := strings.ReplaceAll(strings.ToLower(name), " ", "-")
+
+	return tagMap.getOrCreate(strings.ToLower(name), func() (string, error) {
+		record, err := App.FindFirstRecordByFilter("tags", "name = {:name} || code = {:code}", dbx.Params{"name": name, "code": code})
+		if err == nil {
+			tagNames.update(func(names map[string]string) { names[record.Id] = record.GetString("name") })
+			return record.Id, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return "", err
+		}
+
+		collection, err := App.FindCollectionByNameOrId("tags")
+		if err != nil {
+			slog.Error("ERROR FINDING COLLECTION", "MSG", err)
+			return "", err
+		}
+
+		record = core.NewRecord(collection)
+		record.Set("name", name)
+		record.Set("code", code)
+
+		if err := App.Save(record); err != nil {
+			slog.Error("ERROR SAVING RECORD", "MSG", err)
+			return "", err
+		}
+		tagNames.update(func(names map[string]string) { names[record.Id] = name })
+
+		return record.Id, nil
+	})
+}
+
+// findRecordsForReaction returns the records of the reacted upload post.
+// When the reaction is on a bot message replying to an upload post, the replied post is used.
+func findRecordsForReaction(s *discordgo.Session, r *discordgo.MessageReaction) ([]*core.Record, error) {
+	records, err := findRecordsByDiscordMessage(r.MessageID)
+	if err != nil || len(records) > 0 {
+		return records, err
+	}
+
+	msg, err := s.ChannelMessage(r.ChannelID, r.MessageID)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Author == nil || s.State.User == nil || msg.Author.ID != s.State.User.ID || msg.MessageReference == nil {
+		return nil, nil
+	}
+
+	return findRecordsByDiscordMessage(msg.MessageReference.MessageID)
+}
@@ -0,0 +1,226 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// globalCommandScope in DISCORD_COMMAND_GUILDS registers the commands globally instead of in a guild
+const globalCommandScope = "global"
+
+// defaultCommandGuilds get the commands when DISCORD_COMMAND_GUILDS is not set
+var defaultCommandGuilds = []string{
+	"1169291742504300595",
+	"1298381481739161690",
+}
+
+var (
+	// commandsMu serializes registering and removing commands
+	commandsMu sync.Mutex
+	// registeredCommands are the commands as Discord returned them by guild ID, "" for the global scope
+	registeredCommands = make(map[string][]*discordgo.ApplicationCommand)
+)
+
+// commandScopes returns the guild IDs to register the commands in from DISCORD_COMMAND_GUILDS,
+// a comma separated list where "global" stands for the global scope, returned as an empty guild ID
+func commandScopes() []string {
+	value := os.Getenv("DISCORD_COMMAND_GUILDS")
+	if strings.TrimSpace(value) == "" {
+		return defaultCommandGuilds
+	}
+
+	var scopes []string
+	seen := make(map[string]bool)
+	for _, scope := range strings.Split(value, ",") {
+		scope = strings.TrimSpace(scope)
+		if scope == "" {
+			continue
+		}
+		if strings.EqualFold(scope, globalCommandScope) {
+			scope = ""
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// scopeName names a scope in logs
+func scopeName(guildID string) string {
+	if guildID == "" {
+		return globalCommandScope
+	}
+	return guildID
+}
+
+// syncCommands makes the commands of every scope match the definitions. A scope is only overwritten
+// when its commands differ, so restarts don't re-register anything. A failing scope doesn't stop the others.
+func syncCommands(s *discordgo.Session, commands []*discordgo.ApplicationCommand) error {
+	var errs []error
+	for _, guildID := range commandScopes() {
+		if err := syncScope(s, guildID, commands); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// syncScope makes the commands of a scope match the definitions, adjusted to the permissions of guilds
+func syncScope(s *discordgo.Session, guildID string, commands []*discordgo.ApplicationCommand) error {
+	if guildID != "" {
+		commands = guildCommands(guildID, commands)
+	}
+
+	commandsMu.Lock()
+	defer commandsMu.Unlock()
+
+	appID := s.State.User.ID
+	existing, err := s.ApplicationCommands(appID, guildID)
+	if err != nil {
+		return fmt.Errorf("listing commands in %s: %w", scopeName(guildID), err)
+	}
+
+	if sameCommands(existing, commands) {
+		slog.Info("Slash commands are up to date", "scope", scopeName(guildID), "commands", len(existing))
+		registeredCommands[guildID] = existing
+		return nil
+	}
+
+	created, err := s.ApplicationCommandBulkOverwrite(appID, guildID, commands)
+	if err != nil {
+		return fmt.Errorf("overwriting commands in %s: %w", scopeName(guildID), err)
+	}
+
+	slog.Info("Slash commands registered", "scope", scopeName(guildID), "commands", len(created))
+	registeredCommands[guildID] = created
+	return nil
+}
+
+// resyncGuildCommands registers the commands of a guild again, when the guild is one of the scopes
+func resyncGuildCommands(guildID string) {
+	if guildID == "" || !slices.Contains(commandScopes(), guildID) {
+		return
+	}
+
+	lifecycleMu.Lock()
+	s := session
+	lifecycleMu.Unlock()
+	if s == nil {
+		return
+	}
+
+	if err := syncScope(s, guildID, commandDefinitions()); err != nil {
+		slog.Error("UNABLE TO REGISTER SLASH COMMANDS", "MSG", err)
+	}
+}
+
+// sameCommands reports whether the registered commands match the definitions, ignoring their order
+func sameCommands(registered, commands []*discordgo.ApplicationCommand) bool {
+	if len(registered) != len(commands) {
+		return false
+	}
+
+	signatures := make(map[string]string, len(registered))
+	for _, cmd := range registered {
+		signatures[cmd.Name] = commandSignature(cmd)
+	}
+	for _, cmd := range commands {
+		if signature, ok := signatures[cmd.Name]; !ok || signature != commandSignature(cmd) {
+			return false
+		}
+	}
+	return true
+}
+
+// commandSignature serializes the parts of a command the bot defines. Discord fills in IDs, versions
+// and defaults on the commands it returns, so comparing them as a whole would always find a difference.
+func commandSignature(cmd *discordgo.ApplicationCommand) string {
+	type choice struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	type option struct {
+		Type         discordgo.ApplicationCommandOptionType `json:"type"`
+		Name         string                                 `json:"name"`
+		Description  string                                 `json:"description"`
+		Required     bool                                   `json:"required"`
+		Autocomplete bool                                   `json:"autocomplete"`
+		Choices      []choice                               `json:"choices"`
+		Options      []option                               `json:"options"`
+		MinValue     *float64                               `json:"minValue"`
+		MaxValue     float64                                `json:"maxValue"`
+		MinLength    *int                                   `json:"minLength"`
+		MaxLength    int                                    `json:"maxLength"`
+	}
+
+	var convert func(options []*discordgo.ApplicationCommandOption) []option
+	convert = func(options []*discordgo.ApplicationCommandOption) []option {
+		var converted []option
+		for _, opt := range options {
+			o := option{
+				Type:         opt.Type,
+				Name:         opt.Name,
+				Description:  opt.Description,
+				Required:     opt.Required,
+				Autocomplete: opt.Autocomplete,
+				Options:      convert(opt.Options),
+				MinValue:     opt.MinValue,
+				MaxValue:     opt.MaxValue,
+				MinLength:    opt.MinLength,
+				MaxLength:    opt.MaxLength,
+			}
+			// number choices come back from Discord as float64
+			for _, c := range opt.Choices {
+				o.Choices = append(o.Choices, choice{Name: c.Name, Value: fmt.Sprint(c.Value)})
+			}
+			converted = append(converted, o)
+		}
+		return converted
+	}
+
+	commandType := cmd.Type
+	if commandType == 0 {
+		commandType = discordgo.ChatApplicationCommand
+	}
+
+	signature, _ := json.Marshal(struct {
+		Type                     discordgo.ApplicationCommandType `json:"type"`
+		Name                     string                           `json:"name"`
+		Description              string                           `json:"description"`
+		DefaultMemberPermissions *int64                           `json:"defaultMemberPermissions"`
+		Options                  []option                         `json:"options"`
+	}{
+		Type:                     commandType,
+		Name:                     cmd.Name,
+		Description:              cmd.Description,
+		DefaultMemberPermissions: cmd.DefaultMemberPermissions,
+		Options:                  convert(cmd.Options),
+	})
+	return string(signature)
+}
+
+// RemoveCommands removes the slash commands from every scope they were registered in
+func RemoveCommands(s *discordgo.Session) {
+	commandsMu.Lock()
+	defer commandsMu.Unlock()
+
+	for guildID := range registeredCommands {
+		_, err := s.ApplicationCommandBulkOverwrite(s.State.User.ID, guildID, []*discordgo.ApplicationCommand{})
+		if err != nil {
+			slog.Error("UNABLE TO REMOVE SLASH COMMANDS", "MSG", err, "scope", scopeName(guildID))
+			continue
+		}
+		slog.Info("Slash commands removed", "scope", scopeName(guildID))
+		delete(registeredCommands, guildID)
+	}
+}
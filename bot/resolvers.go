@@ -0,0 +1,640 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ResolvedMedia is a single downloadable file produced by a MediaResolver,
+// together with the metadata that could be inferred from the pasted link
+type ResolvedMedia struct {
+	URL string // direct download URL
+	// FallbackURL is downloaded instead when URL can't be, it replaces URL, Filename and Mirror
+	FallbackURL string
+	Filename    string
+	Filetype    string // "video" or "image", empty if unknown
+	Mirror      string
+	HqMirror    string
+	Source      string
+}
+
+// MediaResolver turns a pasted link from a specific host into downloadable files
+type MediaResolver interface {
+	Name() string
+	Match(link string) bool
+	Resolve(link string) ([]ResolvedMedia, error)
+}
+
+// MediaLink is a link found in a message along with the resolver that claimed it
+type MediaLink struct {
+	URL      string
+	Resolver MediaResolver
+}
+
+// mediaResolvers are tried in order, the first one matching a link wins.
+// directResolver must stay last since it accepts any link to a media file.
+var mediaResolvers = []MediaResolver{
+	imgurAlbumResolver{fetcher: imgurAPI{}},
+	imgurResolver{},
+	pixeldrainResolver{},
+	catboxResolver{},
+	redgifsResolver{},
+	twitterResolver{},
+	discordCDNResolver{},
+	directResolver{},
+}
+
+var linkRegexp = regexp.MustCompile(`https?://[^\s<>()]+`)
+var catboxRegexp = regexp.MustCompile(`^https?://(?:files|litter)\.catbox\.moe/[a-zA-Z0-9]+\.[a-zA-Z0-9]+$`)
+var redgifsRegexp = regexp.MustCompile(`^https?://(?:www\.|v3\.)?(?:redgifs\.com/(?:watch|ifr)|gfycat\.com(?:/[a-z]{2})?)/([a-zA-Z]+)`)
+var twitterRegexp = regexp.MustCompile(`^https?://(?:www\.|mobile\.)?(?:twitter\.com|x\.com|fxtwitter\.com|vxtwitter\.com)/([A-Za-z0-9_]+)/status/([0-9]+)`)
+var discordCDNRegexp = regexp.MustCompile(`^https?://(?:cdn\.discordapp\.com|media\.discordapp\.net)/attachments/[0-9]+/[0-9]+/[^\s?]+`)
+
+var videoExtensions = map[string]bool{".mp4": true, ".webm": true, ".mov": true, ".mkv": true, ".gifv": true}
+var imageExtensions = map[string]bool{".gif": true, ".png": true, ".jpg": true, ".jpeg": true, ".webp": true}
+
+var resolverClient = &http.Client{Timeout: 30 * time.Second}
+
+// errNoImgurClientID is returned for imgur albums when IMGUR_CLIENT_ID is not set,
+// uploaders are told since reposting won't help until the bot is configured
+var errNoImgurClientID = errors.New("IMGUR_CLIENT_ID is required to expand imgur albums")
+
+// metadataLinkKeys are the metadata lines whose link describes the upload, like "source: <link>",
+// so the link is never uploaded itself
+var metadataLinkKeys = map[string]bool{"source": true, "discord": true, "mirror": true, "hqMirror": true}
+
+// findMediaLinks returns every link in the message content that one of the media resolvers can handle
+func findMediaLinks(content string) []MediaLink {
+	var links []MediaLink
+	hasPrimaryMedia := false
+	seen := make(map[string]bool)
+
+	var raws []string
+	for _, line := range strings.Split(content, "\n") {
+		if key, _, ok := strings.Cut(line, ":"); ok && metadataLinkKeys[strings.TrimSpace(key)] {
+			continue
+		}
+		raws = append(raws, linkRegexp.FindAllString(line, -1)...)
+	}
+
+	for _, raw := range raws {
+		link := strings.TrimRight(raw, ".,;:!?*_~`'\"")
+		if seen[link] {
+			continue
+		}
+		seen[link] = true
+
+		for _, resolver := range mediaResolvers {
+			if resolver.Match(link) {
+				links = append(links, MediaLink{URL: link, Resolver: resolver})
+				if _, ok := resolver.(pixeldrainResolver); !ok {
+					hasPrimaryMedia = true
+				}
+				break
+			}
+		}
+	}
+
+	// A pixeldrain link posted next to other media is the HQ mirror of that media
+	// (see extractMetadata), so it only becomes an upload of its own when alone
+	if !hasPrimaryMedia {
+		return links
+	}
+
+	var result []MediaLink
+	for _, link := range links {
+		if _, ok := link.Resolver.(pixeldrainResolver); !ok {
+			result = append(result, link)
+		}
+	}
+
+	return result
+}
+
+// resolveMediaLinks resolves every link into downloadable media, skipping links that fail.
+// The errors of the skipped links are returned joined, for unresolvedNotice.
+func resolveMediaLinks(links []MediaLink) ([]ResolvedMedia, error) {
+	var result []ResolvedMedia
+	var errs []error
+	for _, link := range links {
+		media, err := link.Resolver.Resolve(link.URL)
+		if err != nil {
+			slog.Warn("unable to resolve media link", "RESOLVER", link.Resolver.Name(), "LINK", link.URL, "MSG", err)
+			errs = append(errs, err)
+			continue
+		}
+		result = append(result, media...)
+	}
+
+	return result, errors.Join(errs...)
+}
+
+// unresolvedNotice tells the uploader about links skipped because of how the bot is set up,
+// empty when the links failed for reasons of their own
+func unresolvedNotice(err error) string {
+	if errors.Is(err, errNoImgurClientID) {
+		return "Imgur albums can't be expanded on this server yet, post the links of their items instead."
+	}
+	return ""
+}
+
+// applyTo copies the metadata suggested by the resolver into the item metadata.
+// Filetype and mirror are per item, the other fields only fill in what the uploader left empty.
+func (r ResolvedMedia) applyTo(metadata *Metadata) {
+	if r.Filetype != "" {
+		metadata.Filetype = r.Filetype
+	}
+	if r.Mirror != "" {
+		metadata.Mirror = r.Mirror
+	}
+	if metadata.HqMirror == "" {
+		metadata.HqMirror = r.HqMirror
+	}
+	if metadata.Source == "" {
+		metadata.Source = r.Source
+	}
+}
+
+// filetypeFromFilename guesses "video" or "image" from the file extension
+func filetypeFromFilename(filename string) string {
+	ext := strings.ToLower(path.Ext(filename))
+	if videoExtensions[ext] {
+		return "video"
+	}
+	if imageExtensions[ext] {
+		return "image"
+	}
+	return ""
+}
+
+// filenameFromLink returns the last path segment of a link without its query string
+func filenameFromLink(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return path.Base(link)
+	}
+	return path.Base(u.Path)
+}
+
+// getJSON fetches a JSON document and decodes it into v
+func getJSON(link string, headers map[string]string, v any) error {
+	req, err := http.NewRequest("GET", link, nil)
+	if err != nil {
+		return err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := resolverClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status from %s: %s", req.URL.Host, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// imgurResolver handles single imgur media links
+type imgurResolver struct{}
+
+func (imgurResolver) Name() string { return "imgur" }
+
+func (imgurResolver) Match(link string) bool {
+	return imgurRegexp.MatchString(link) && !imgurAlbumRegexp.MatchString(link)
+}
+
+func (imgurResolver) Resolve(link string) ([]ResolvedMedia, error) {
+	match := imgurRegexp.FindStringSubmatch(link)
+	if match == nil {
+		return nil, fmt.Errorf("not an imgur link: %s", link)
+	}
+
+	normalized := normalizeImgurLink(match)
+
+	// the filetype is left empty, it is detected from the downloaded file
+	media := ResolvedMedia{
+		URL:      normalized,
+		Filename: path.Base(normalized),
+		Mirror:   normalized,
+	}
+
+	// Links without an extension are normalized to .mp4, which only exists for animated media.
+	// Still images are served under their image extension, tried when the .mp4 can't be downloaded.
+	if match[3] == "" {
+		media.FallbackURL = strings.TrimSuffix(normalized, ".mp4") + ".jpeg"
+	}
+
+	return []ResolvedMedia{media}, nil
+}
+
+// imgurImage is a single media item inside an imgur album or gallery post
+type imgurImage struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Link     string `json:"link"`
+	MP4      string `json:"mp4"`
+	Animated bool   `json:"animated"`
+}
+
+// imgurAlbumFetcher lists the media inside an imgur album or gallery post
+type imgurAlbumFetcher interface {
+	AlbumImages(albumID string) ([]imgurImage, error)
+	GalleryImages(galleryID string) ([]imgurImage, error)
+}
+
+// imgurAlbumResolver expands imgur album (/a/), gallery (/gallery/) and tag (/t/<tag>/) links into their media
+type imgurAlbumResolver struct {
+	fetcher imgurAlbumFetcher
+}
+
+func (imgurAlbumResolver) Name() string { return "imgur-album" }
+
+func (imgurAlbumResolver) Match(link string) bool {
+	return imgurAlbumRegexp.MatchString(link)
+}
+
+func (r imgurAlbumResolver) Resolve(link string) ([]ResolvedMedia, error) {
+	match := imgurAlbumRegexp.FindStringSubmatch(link)
+	if match == nil {
+		return nil, fmt.Errorf("not an imgur album link: %s", link)
+	}
+	kind, id := match[1], imgurPostID(match[2])
+
+	var images []imgurImage
+	var err error
+	if kind == "a" {
+		images, err = r.fetcher.AlbumImages(id)
+	} else {
+		images, err = r.fetcher.GalleryImages(id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(images) == 0 {
+		return nil, fmt.Errorf("imgur album is empty: %s", link)
+	}
+
+	result := make([]ResolvedMedia, 0, len(images))
+	for _, image := range images {
+		result = append(result, image.toResolvedMedia())
+	}
+
+	return result, nil
+}
+
+// imgurPostID strips the title slug from new-style links like imgur.com/gallery/some-title-AbC123
+func imgurPostID(slug string) string {
+	if idx := strings.LastIndex(slug, "-"); idx != -1 {
+		return slug[idx+1:]
+	}
+	return slug
+}
+
+// toResolvedMedia prefers the mp4 rendition of animated items, same as normalizeImgurLink does for single links
+func (i imgurImage) toResolvedMedia() ResolvedMedia {
+	link := i.Link
+	filetype := "image"
+	if i.Animated || strings.HasPrefix(i.Type, "video/") {
+		filetype = "video"
+		if i.MP4 != "" {
+			link = i.MP4
+		}
+	}
+
+	return ResolvedMedia{
+		URL:      link,
+		Filename: path.Base(link),
+		Filetype: filetype,
+		Mirror:   link,
+	}
+}
+
+// imgurAPI fetches albums through the imgur API using the IMGUR_CLIENT_ID environment variable
+type imgurAPI struct{}
+
+func (imgurAPI) get(endpoint string, v any) error {
+	clientID := os.Getenv("IMGUR_CLIENT_ID")
+	if clientID == "" {
+		return errNoImgurClientID
+	}
+
+	var resp struct {
+		Data    json.RawMessage `json:"data"`
+		Success bool            `json:"success"`
+	}
+	headers := map[string]string{"Authorization": "Client-ID " + clientID}
+	if err := getJSON("https://api.imgur.com/3/"+endpoint, headers, &resp); err != nil {
+		return err
+	}
+	if !resp.Success {
+		return fmt.Errorf("imgur API request failed: %s", endpoint)
+	}
+
+	return json.Unmarshal(resp.Data, v)
+}
+
+func (api imgurAPI) AlbumImages(albumID string) ([]imgurImage, error) {
+	var images []imgurImage
+	if err := api.get("album/"+albumID+"/images", &images); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+func (api imgurAPI) GalleryImages(galleryID string) ([]imgurImage, error) {
+	// A gallery post is either an album or a single image
+	var item struct {
+		imgurImage
+		IsAlbum bool         `json:"is_album"`
+		Images  []imgurImage `json:"images"`
+	}
+	if err := api.get("gallery/"+galleryID, &item); err != nil {
+		return nil, err
+	}
+
+	if item.IsAlbum {
+		return item.Images, nil
+	}
+	return []imgurImage{item.imgurImage}, nil
+}
+
+// pixeldrainResolver handles pixeldrain files (/u/) and lists (/l/)
+type pixeldrainResolver struct{}
+
+type pixeldrainFile struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	MimeType string `json:"mime_type"`
+}
+
+func (pixeldrainResolver) Name() string { return "pixeldrain" }
+
+func (pixeldrainResolver) Match(link string) bool {
+	return pixeldrainRegexp.MatchString(link)
+}
+
+func (pixeldrainResolver) Resolve(link string) ([]ResolvedMedia, error) {
+	match := pixeldrainRegexp.FindString(link)
+	id := path.Base(match)
+
+	var files []pixeldrainFile
+	if strings.Contains(match, "/l/") {
+		var list struct {
+			Files []pixeldrainFile `json:"files"`
+		}
+		if err := getJSON("https://pixeldrain.com/api/list/"+id, nil, &list); err != nil {
+			return nil, err
+		}
+		files = list.Files
+	} else {
+		var file pixeldrainFile
+		if err := getJSON("https://pixeldrain.com/api/file/"+id+"/info", nil, &file); err != nil {
+			return nil, err
+		}
+		files = []pixeldrainFile{file}
+	}
+
+	var result []ResolvedMedia
+	for _, file := range files {
+		filetype := ""
+		if strings.HasPrefix(file.MimeType, "video/") {
+			filetype = "video"
+		} else if strings.HasPrefix(file.MimeType, "image/") {
+			filetype = "image"
+		} else {
+			continue
+		}
+
+		result = append(result, ResolvedMedia{
+			URL:      "https://pixeldrain.com/api/file/" + file.ID,
+			Filename: file.Name,
+			Filetype: filetype,
+			HqMirror: link,
+		})
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no media files found on pixeldrain: %s", link)
+	}
+
+	return result, nil
+}
+
+// catboxResolver handles catbox and litterbox files, which are already direct links
+type catboxResolver struct{}
+
+func (catboxResolver) Name() string { return "catbox" }
+
+func (catboxResolver) Match(link string) bool {
+	return catboxRegexp.MatchString(link)
+}
+
+func (catboxResolver) Resolve(link string) ([]ResolvedMedia, error) {
+	filename := filenameFromLink(link)
+	return []ResolvedMedia{{
+		URL:      link,
+		Filename: filename,
+		Filetype: filetypeFromFilename(filename),
+		Mirror:   link,
+	}}, nil
+}
+
+// redgifsResolver handles redgifs links and the gfycat links that moved to redgifs
+type redgifsResolver struct{}
+
+func (redgifsResolver) Name() string { return "redgifs" }
+
+func (redgifsResolver) Match(link string) bool {
+	return redgifsRegexp.MatchString(link)
+}
+
+func (redgifsResolver) Resolve(link string) ([]ResolvedMedia, error) {
+	match := redgifsRegexp.FindStringSubmatch(link)
+	if match == nil {
+		return nil, fmt.Errorf("not a redgifs link: %s", link)
+	}
+	id := strings.ToLower(match[1])
+
+	// redgifs requires a (free) temporary token for every API call
+	var auth struct {
+		Token string `json:"token"`
+	}
+	if err := getJSON("https://api.redgifs.com/v2/auth/temporary", nil, &auth); err != nil {
+		return nil, err
+	}
+
+	var gif struct {
+		Gif struct {
+			URLs struct {
+				HD string `json:"hd"`
+				SD string `json:"sd"`
+			} `json:"urls"`
+		} `json:"gif"`
+	}
+	headers := map[string]string{"Authorization": "Bearer " + auth.Token}
+	if err := getJSON("https://api.redgifs.com/v2/gifs/"+id, headers, &gif); err != nil {
+		return nil, err
+	}
+
+	mediaURL := gif.Gif.URLs.HD
+	if mediaURL == "" {
+		mediaURL = gif.Gif.URLs.SD
+	}
+	if mediaURL == "" {
+		return nil, fmt.Errorf("no media found on redgifs: %s", link)
+	}
+
+	return []ResolvedMedia{{
+		URL:      mediaURL,
+		Filename: id + ".mp4",
+		Filetype: "video",
+		Mirror:   "https://www.redgifs.com/watch/" + id,
+	}}, nil
+}
+
+// twitterResolver handles Twitter/X posts through the fxtwitter API
+type twitterResolver struct{}
+
+func (twitterResolver) Name() string { return "twitter" }
+
+func (twitterResolver) Match(link string) bool {
+	return twitterRegexp.MatchString(link)
+}
+
+func (twitterResolver) Resolve(link string) ([]ResolvedMedia, error) {
+	match := twitterRegexp.FindStringSubmatch(link)
+	if match == nil {
+		return nil, fmt.Errorf("not a twitter link: %s", link)
+	}
+	user, statusID := match[1], match[2]
+
+	var resp struct {
+		Tweet struct {
+			Media struct {
+				Videos []struct {
+					URL string `json:"url"`
+				} `json:"videos"`
+				Photos []struct {
+					URL string `json:"url"`
+				} `json:"photos"`
+			} `json:"media"`
+		} `json:"tweet"`
+	}
+	if err := getJSON(fmt.Sprintf("https://api.fxtwitter.com/%s/status/%s", user, statusID), nil, &resp); err != nil {
+		return nil, err
+	}
+
+	source := fmt.Sprintf("https://x.com/%s/status/%s", user, statusID)
+
+	var result []ResolvedMedia
+	for _, video := range resp.Tweet.Media.Videos {
+		result = append(result, ResolvedMedia{
+			URL:      video.URL,
+			Filename: filenameFromLink(video.URL),
+			Filetype: "video",
+			Source:   source,
+		})
+	}
+	for _, photo := range resp.Tweet.Media.Photos {
+		result = append(result, ResolvedMedia{
+			URL:      photo.URL,
+			Filename: filenameFromLink(photo.URL),
+			Filetype: "image",
+			Source:   source,
+		})
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no media found in tweet: %s", link)
+	}
+
+	return result, nil
+}
+
+// discordCDNResolver handles attachment links copied from other Discord messages.
+// These links expire, so they are never stored as a mirror.
+type discordCDNResolver struct{}
+
+func (discordCDNResolver) Name() string { return "discord-cdn" }
+
+func (discordCDNResolver) Match(link string) bool {
+	return discordCDNRegexp.MatchString(link)
+}
+
+func (discordCDNResolver) Resolve(link string) ([]ResolvedMedia, error) {
+	filename := filenameFromLink(link)
+	return []ResolvedMedia{{
+		URL:      link,
+		Filename: filename,
+		Filetype: filetypeFromFilename(filename),
+	}}, nil
+}
+
+// directResolver handles any other link pointing straight at a media file
+type directResolver struct{}
+
+func (directResolver) Name() string { return "direct" }
+
+func (directResolver) Match(link string) bool {
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || isLocalHost(u.Hostname()) {
+		return false
+	}
+	return filetypeFromFilename(filenameFromLink(link)) != ""
+}
+
+func (directResolver) Resolve(link string) ([]ResolvedMedia, error) {
+	// any host can be linked, the bot must not be made to fetch from its own network
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := net.LookupIP(u.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if isPrivateIP(ip) {
+			return nil, fmt.Errorf("link points at a private address: %s", link)
+		}
+	}
+
+	filename := filenameFromLink(link)
+	return []ResolvedMedia{{
+		URL:      link,
+		Filename: filename,
+		Filetype: filetypeFromFilename(filename),
+		Mirror:   link,
+	}}, nil
+}
+
+// isLocalHost reports whether a host is localhost or a private IP address, without resolving names
+func isLocalHost(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "" || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && isPrivateIP(ip)
+}
+
+// isPrivateIP reports whether an address is loopback, private, link-local or unspecified
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified()
+}
@@ -0,0 +1,187 @@
+package bot
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// fakeImgurFetcher serves albums and gallery posts from memory and records the IDs asked for
+type fakeImgurFetcher struct {
+	albums    map[string][]imgurImage
+	galleries map[string][]imgurImage
+	err       error
+	requested []string
+}
+
+func (f *fakeImgurFetcher) AlbumImages(albumID string) ([]imgurImage, error) {
+	f.requested = append(f.requested, "album/"+albumID)
+	return f.albums[albumID], f.err
+}
+
+func (f *fakeImgurFetcher) GalleryImages(galleryID string) ([]imgurImage, error) {
+	f.requested = append(f.requested, "gallery/"+galleryID)
+	return f.galleries[galleryID], f.err
+}
+
+func TestImgurAlbumResolver(t *testing.T) {
+	still := imgurImage{ID: "still", Type: "image/jpeg", Link: "https://i.imgur.com/still.jpeg"}
+	animated := imgurImage{ID: "anim", Type: "image/gif", Link: "https://i.imgur.com/anim.gif", MP4: "https://i.imgur.com/anim.mp4", Animated: true}
+	video := imgurImage{ID: "vid", Type: "video/mp4", Link: "https://i.imgur.com/vid.mp4"}
+
+	fetcher := &fakeImgurFetcher{
+		albums:    map[string][]imgurImage{"AbC123": {still, animated}},
+		galleries: map[string][]imgurImage{"XyZ789": {video}},
+	}
+	resolver := imgurAlbumResolver{fetcher: fetcher}
+
+	tests := []struct {
+		name      string
+		link      string
+		requested string
+		want      []ResolvedMedia
+		wantErr   bool
+	}{
+		{
+			name:      "album",
+			link:      "https://imgur.com/a/AbC123",
+			requested: "album/AbC123",
+			want: []ResolvedMedia{
+				{URL: still.Link, Filename: "still.jpeg", Filetype: "image", Mirror: still.Link},
+				{URL: animated.MP4, Filename: "anim.mp4", Filetype: "video", Mirror: animated.MP4},
+			},
+		},
+		{
+			name:      "gallery with a title slug",
+			link:      "https://imgur.com/gallery/some-title-XyZ789",
+			requested: "gallery/XyZ789",
+			want:      []ResolvedMedia{{URL: video.Link, Filename: "vid.mp4", Filetype: "video", Mirror: video.Link}},
+		},
+		{
+			name:      "tag posts are gallery posts",
+			link:      "https://m.imgur.com/t/kpop/XyZ789",
+			requested: "gallery/XyZ789",
+			want:      []ResolvedMedia{{URL: video.Link, Filename: "vid.mp4", Filetype: "video", Mirror: video.Link}},
+		},
+		{
+			name:      "empty album",
+			link:      "https://imgur.com/a/empty",
+			requested: "album/empty",
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !resolver.Match(tt.link) {
+				t.Fatalf("%s is not matched", tt.link)
+			}
+
+			fetcher.requested = nil
+			got, err := resolver.Resolve(tt.link)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if !slices.Equal(fetcher.requested, []string{tt.requested}) {
+				t.Errorf("requested = %v, want %s", fetcher.requested, tt.requested)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("media = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImgurAlbumResolverErrors(t *testing.T) {
+	fetchErr := errors.New("imgur is down")
+	resolver := imgurAlbumResolver{fetcher: &fakeImgurFetcher{err: fetchErr}}
+	if _, err := resolver.Resolve("https://imgur.com/a/AbC123"); !errors.Is(err, fetchErr) {
+		t.Errorf("error = %v, want %v", err, fetchErr)
+	}
+
+	// single imgur links are left to imgurResolver
+	if resolver.Match("https://i.imgur.com/AbC123.mp4") {
+		t.Error("single imgur link matched as an album")
+	}
+}
+
+func TestMissingImgurClientIDIsReported(t *testing.T) {
+	t.Setenv("IMGUR_CLIENT_ID", "")
+
+	links := findMediaLinks("https://imgur.com/a/AbC123 https://files.catbox.moe/abc123.mp4")
+	media, err := resolveMediaLinks(links)
+	if !errors.Is(err, errNoImgurClientID) {
+		t.Fatalf("error = %v, want %v", err, errNoImgurClientID)
+	}
+	if len(media) != 1 || media[0].URL != "https://files.catbox.moe/abc123.mp4" {
+		t.Errorf("media = %+v, want the catbox link alone", media)
+	}
+	if unresolvedNotice(err) == "" {
+		t.Error("uploader is not told about the missing client ID")
+	}
+
+	if notice := unresolvedNotice(errors.New("imgur album is empty")); notice != "" {
+		t.Errorf("notice = %q for a link failing on its own", notice)
+	}
+}
+
+func TestFindMediaLinksSkipsMetadataLinks(t *testing.T) {
+	content := "title: stage\n" +
+		"source: https://x.com/ive/status/123\n" +
+		"mirror: https://files.catbox.moe/abc123.mp4\n" +
+		"https://i.imgur.com/AbC123.mp4"
+
+	links := findMediaLinks(content)
+	if len(links) != 1 || links[0].URL != "https://i.imgur.com/AbC123.mp4" {
+		var urls []string
+		for _, link := range links {
+			urls = append(urls, link.URL)
+		}
+		t.Errorf("links = %v, want the imgur link alone", urls)
+	}
+}
+
+func TestDirectResolverSkipsLocalHosts(t *testing.T) {
+	tests := []struct {
+		link  string
+		match bool
+	}{
+		{"https://example.com/clip.mp4", true},
+		{"http://127.0.0.1/clip.mp4", false},
+		{"http://localhost:8090/clip.mp4", false},
+		{"http://10.0.0.5/clip.mp4", false},
+		{"http://192.168.1.2/clip.gif", false},
+		{"http://169.254.169.254/latest/clip.png", false},
+		{"http://[::1]/clip.mp4", false},
+		{"ftp://example.com/clip.mp4", false},
+		{"https://example.com/page", false},
+	}
+
+	for _, tt := range tests {
+		if got := (directResolver{}).Match(tt.link); got != tt.match {
+			t.Errorf("Match(%s) = %v, want %v", tt.link, got, tt.match)
+		}
+	}
+}
+
+func TestImgurResolverFallsBackToImages(t *testing.T) {
+	tests := []struct {
+		link     string
+		url      string
+		fallback string
+	}{
+		{"https://imgur.com/AbC123", "https://i.imgur.com/AbC123.mp4", "https://i.imgur.com/AbC123.jpeg"},
+		{"https://i.imgur.com/AbC123.gifv", "https://i.imgur.com/AbC123.mp4", ""},
+		{"https://i.imgur.com/AbC123.png", "https://i.imgur.com/AbC123.png", ""},
+	}
+
+	for _, tt := range tests {
+		media, err := (imgurResolver{}).Resolve(tt.link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(media) != 1 || media[0].URL != tt.url || media[0].FallbackURL != tt.fallback {
+			t.Errorf("Resolve(%s) = %+v, want %s falling back to %q", tt.link, media, tt.url, tt.fallback)
+		}
+	}
+}
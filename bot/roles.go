@@ -0,0 +1,332 @@
+package bot
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// idolGroupsFromRoles returns the idols and groups of pinged roles, in the order they were pinged.
+// Roles mapped in "discord_roles" use their idol and group records, other roles fall back to
+// names like "Yujin [IVE]". Roles that are neither are skipped, the others still count.
+func idolGroupsFromRoles(s *discordgo.Session, guildID string, roleIDs []string) []IdolGroup {
+	mapped, err := loadRoleMappings(guildID, roleIDs)
+	if err != nil {
+		slog.Error("UNABLE TO LOAD ROLE MAPPINGS", "GUILD", guildID, "MSG", err)
+	}
+
+	var unmapped []string
+	for _, roleID := range roleIDs {
+		if _, ok := mapped[roleID]; !ok {
+			unmapped = append(unmapped, roleID)
+		}
+	}
+
+	var roleNames map[string]string
+	if len(unmapped) > 0 {
+		// the mapped roles are still used when the guild roles can't be fetched
+		roleNames, _ = getRoleNamesFromIDs(s, guildID, unmapped)
+	}
+
+	var result []IdolGroup
+	for _, roleID := range roleIDs {
+		if idolGroup, ok := mapped[roleID]; ok {
+			result = append(result, idolGroup)
+			continue
+		}
+		name, ok := roleNames[roleID]
+		if !ok {
+			continue
+		}
+		if idolGroups := extractIdolAndGroupFromRoles([]string{name}); len(idolGroups) > 0 {
+			result = append(result, idolGroups...)
+		} else {
+			slog.Info("ROLE IS NOT MAPPED TO AN IDOL OR GROUP, SKIPPING", "ROLE", roleID, "NAME", name)
+		}
+	}
+
+	return result
+}
+
+// loadRoleMappings returns the idol and group names of the roles mapped in "discord_roles" by role ID.
+// A role mapped to an idol alone gets the group of the idol.
+func loadRoleMappings(guildID string, roleIDs []string) (map[string]IdolGroup, error) {
+	if len(roleIDs) == 0 {
+		return nil, nil
+	}
+
+	params := dbx.Params{"guildId": guildID}
+	conditions := make([]string, 0, len(roleIDs))
+	for n, roleID := range roleIDs {
+		key := fmt.Sprintf("role%d", n)
+		params[key] = roleID
+		conditions = append(conditions, "roleId = {:"+key+"}")
+	}
+
+	filter := "guildId = {:guildId} && (" + strings.Join(conditions, " || ") + ")"
+	records, err := App.FindRecordsByFilter("discord_roles", filter, "", 0, 0, params)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	if errs := App.ExpandRecords(records, []string{"idol", "idol.group", "group"}, nil); len(errs) > 0 {
+		slog.Warn("UNABLE TO EXPAND ROLE MAPPINGS", "MSG", errs)
+	}
+
+	mappings := make(map[string]IdolGroup, len(records))
+	for _, record := range records {
+		if idolGroup := roleMappingIdolGroup(record); idolGroup.Idol != "" || idolGroup.Group != "" {
+			mappings[record.GetString("roleId")] = idolGroup
+		}
+	}
+
+	return mappings, nil
+}
+
+// roleMappingIdolGroup returns the names of an expanded "discord_roles" record.
+// Records whose idol and group were deleted return an empty IdolGroup.
+func roleMappingIdolGroup(record *core.Record) IdolGroup {
+	var idolGroup IdolGroup
+	if idol := record.ExpandedOne("idol"); idol != nil {
+		idolGroup.Idol = idol.GetString("name")
+		if group := idol.ExpandedOne("group"); group != nil {
+			idolGroup.Group = group.GetString("name")
+		}
+	}
+	if group := record.ExpandedOne("group"); group != nil {
+		idolGroup.Group = group.GetString("name")
+	}
+	return idolGroup
+}
+
+// adminRoleOptions are the /admin subcommands mapping the roles of a guild to idols and groups
+func adminRoleOptions() *discordgo.ApplicationCommandOption {
+	role := func(description string) *discordgo.ApplicationCommandOption {
+		return &discordgo.ApplicationCommandOption{
+			Name:        "role",
+			Description: description,
+			Type:        discordgo.ApplicationCommandOptionRole,
+			Required:    true,
+		}
+	}
+
+	return &discordgo.ApplicationCommandOption{
+		Name:        "role",
+		Description: "Map the roles pinged in upload posts to idols and groups",
+		Type:        discordgo.ApplicationCommandOptionSubCommandGroup,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Name:        "map",
+				Description: "Map a role to an idol, a group, or both",
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Options: []*discordgo.ApplicationCommandOption{
+					role("The role to map"),
+					{
+						Name:         "idol",
+						Description:  "The idol the role stands for",
+						Type:         discordgo.ApplicationCommandOptionString,
+						Autocomplete: true,
+					},
+					{
+						Name:         "group",
+						Description:  "The group the role stands for, defaults to the group of the idol",
+						Type:         discordgo.ApplicationCommandOptionString,
+						Autocomplete: true,
+					},
+				},
+			},
+			{
+				Name:        "unmap",
+				Description: "Remove the mapping of a role, its name is used again",
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Options: []*discordgo.ApplicationCommandOption{
+					role("The role to unmap"),
+				},
+			},
+			{
+				Name:        "list",
+				Description: "List the mapped roles of this server",
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+			},
+		},
+	}
+}
+
+// handleAdminRoleCommand runs a /admin role subcommand, mappings are read on every post so nothing is reloaded
+func handleAdminRoleCommand(s *discordgo.Session, i *discordgo.InteractionCreate, subcommand *discordgo.ApplicationCommandInteractionDataOption) {
+	var roleID string
+	options := make(map[string]string)
+	for _, opt := range subcommand.Options {
+		if opt.Type == discordgo.ApplicationCommandOptionRole {
+			roleID, _ = opt.Value.(string)
+			continue
+		}
+		options[opt.Name] = strings.TrimSpace(opt.StringValue())
+	}
+
+	var (
+		summary   string
+		recordIDs []string
+		err       error
+	)
+	switch subcommand.Name {
+	case "map":
+		summary, recordIDs, err = mapRole(i.GuildID, roleID, options)
+	case "unmap":
+		summary, recordIDs, err = unmapRole(i.GuildID, roleID)
+	case "list":
+		summary, err = listRoleMappings(i.GuildID)
+	default:
+		err = fmt.Errorf("Unknown admin command.")
+	}
+	if err != nil {
+		respondEphemeral(s, i.Interaction, err.Error())
+		return
+	}
+
+	if subcommand.Name != "list" {
+		recordAudit(AuditEntry{
+			Action:    "admin:role:" + subcommand.Name,
+			ActorID:   getUserID(i),
+			GuildID:   i.GuildID,
+			ChannelID: i.ChannelID,
+			Records:   recordIDs,
+			Details:   map[string]any{"role": roleID, "options": options},
+		})
+	}
+
+	respondEphemeral(s, i.Interaction, summary)
+}
+
+// findRoleMapping returns the "discord_roles" record of a role, nil when it isn't mapped
+func findRoleMapping(guildID, roleID string) (*core.Record, error) {
+	record, err := App.FindFirstRecordByFilter("discord_roles", "guildId = {:guildId} && roleId = {:roleId}",
+		dbx.Params{"guildId": guildID, "roleId": roleID})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return record, err
+}
+
+func mapRole(guildID, roleID string, options map[string]string) (string, []string, error) {
+	if roleID == "" {
+		return "", nil, fmt.Errorf("Give the role to map.")
+	}
+	if options["idol"] == "" && options["group"] == "" {
+		return "", nil, fmt.Errorf("Give the idol or the group the role stands for.")
+	}
+
+	var idol, group *core.Record
+	var err error
+	if options["idol"] != "" {
+		if idol, err = adminEntities["idol"].find(options["idol"]); err != nil {
+			return "", nil, err
+		}
+	}
+	if options["group"] != "" {
+		if group, err = adminEntities["group"].find(options["group"]); err != nil {
+			return "", nil, err
+		}
+	}
+	if idol != nil && group != nil && idol.GetString("group") != group.Id {
+		return "", nil, fmt.Errorf("%s is not in %s.", idol.GetString("name"), group.GetString("name"))
+	}
+
+	record, err := findRoleMapping(guildID, roleID)
+	if err != nil {
+		slog.Error("UNABLE TO FIND ROLE MAPPING", "ROLE", roleID, "MSG", err)
+		return "", nil, fmt.Errorf("Could not query database.")
+	}
+	if record == nil {
+		collection, err := App.FindCollectionByNameOrId("discord_roles")
+		if err != nil {
+			slog.Error("ERROR FINDING COLLECTION", "MSG", err)
+			return "", nil, fmt.Errorf("Could not query database.")
+		}
+		record = core.NewRecord(collection)
+		record.Set("guildId", guildID)
+		record.Set("roleId", roleID)
+	}
+
+	var names []string
+	record.Set("idol", "")
+	record.Set("group", "")
+	if idol != nil {
+		record.Set("idol", idol.Id)
+		names = append(names, idol.GetString("name"))
+	}
+	if group != nil {
+		record.Set("group", group.Id)
+		names = append(names, group.GetString("name"))
+	}
+
+	if err := App.Save(record); err != nil {
+		slog.Error("ERROR SAVING RECORD", "MSG", err)
+		return "", nil, fmt.Errorf("Could not save the role mapping: %v", err)
+	}
+
+	return fmt.Sprintf("Pinging <@&%s> now tags uploads with **%s**.", roleID, strings.Join(names, "** and **")), []string{record.Id}, nil
+}
+
+func unmapRole(guildID, roleID string) (string, []string, error) {
+	record, err := findRoleMapping(guildID, roleID)
+	if err != nil {
+		slog.Error("UNABLE TO FIND ROLE MAPPING", "ROLE", roleID, "MSG", err)
+		return "", nil, fmt.Errorf("Could not query database.")
+	}
+	if record == nil {
+		return "", nil, fmt.Errorf("<@&%s> is not mapped.", roleID)
+	}
+
+	if err := App.Delete(record); err != nil {
+		slog.Error("ERROR DELETING RECORD", "MSG", err)
+		return "", nil, fmt.Errorf("Could not remove the role mapping: %v", err)
+	}
+
+	return fmt.Sprintf("Removed the mapping of <@&%s>, its name is used again.", roleID), []string{record.Id}, nil
+}
+
+// listRoleMappings renders the mapped roles of a guild, cut to what fits in a message
+func listRoleMappings(guildID string) (string, error) {
+	records, err := App.FindRecordsByFilter("discord_roles", "guildId = {:guildId}", "created", 0, 0, dbx.Params{"guildId": guildID})
+	if err != nil {
+		slog.Error("UNABLE TO LOAD ROLE MAPPINGS", "GUILD", guildID, "MSG", err)
+		return "", fmt.Errorf("Could not query database.")
+	}
+	if len(records) == 0 {
+		return "No roles are mapped, roles named like \"Yujin [IVE]\" are used as they are.", nil
+	}
+	if errs := App.ExpandRecords(records, []string{"idol", "idol.group", "group"}, nil); len(errs) > 0 {
+		slog.Warn("UNABLE TO EXPAND ROLE MAPPINGS", "MSG", errs)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d mapped roles:", len(records))
+	for n, record := range records {
+		idolGroup := roleMappingIdolGroup(record)
+		target := idolGroup.Group
+		if idolGroup.Idol != "" {
+			target = fmt.Sprintf("%s [%s]", idolGroup.Idol, idolGroup.Group)
+		}
+		if target == "" {
+			target = "nothing, the idol or group was deleted"
+		}
+
+		line := fmt.Sprintf("\n<@&%s> → %s", record.GetString("roleId"), target)
+		if b.Len()+len(line) > 1900 {
+			fmt.Fprintf(&b, "\n… and %d more", len(records)-n)
+			break
+		}
+		b.WriteString(line)
+	}
+
+	return b.String(), nil
+}
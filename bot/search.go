@@ -0,0 +1,248 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"kcat-v3-be/bot/utils"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+var searchCommand = &discordgo.ApplicationCommand{
+	Name:        "search",
+	Description: "Search the KpopCat archive.",
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Name:         "idol",
+			Description:  "Idol name",
+			Type:         discordgo.ApplicationCommandOptionString,
+			Autocomplete: true,
+		},
+		{
+			Name:         "group",
+			Description:  "Group name",
+			Type:         discordgo.ApplicationCommandOptionString,
+			Autocomplete: true,
+		},
+		{
+			Name:         "tag",
+			Description:  "Tag name",
+			Type:         discordgo.ApplicationCommandOptionString,
+			Autocomplete: true,
+		},
+		{
+			Name:        "uploader",
+			Description: "Uploader name",
+			Type:        discordgo.ApplicationCommandOptionString,
+		},
+		{
+			Name:        "from",
+			Description: "Only items dated on or after this day (YYMMDD)",
+			Type:        discordgo.ApplicationCommandOptionString,
+		},
+		{
+			Name:        "to",
+			Description: "Only items dated on or before this day (YYMMDD)",
+			Type:        discordgo.ApplicationCommandOptionString,
+		},
+		{
+			Name:        "title",
+			Description: "Text contained in the title",
+			Type:        discordgo.ApplicationCommandOptionString,
+		},
+		{
+			Name:        "perpage",
+			Description: "How many results to show per page (1‑5, default 5)",
+			Type:        discordgo.ApplicationCommandOptionInteger,
+		},
+		{
+			Name:        "shared",
+			Description: "Let anyone change pages, not only you.",
+			Type:        discordgo.ApplicationCommandOptionBoolean,
+		},
+	},
+}
+
+func handleSearchCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	var perPage int64 = 5
+	var shared bool
+	options := make(map[string]string)
+	for _, opt := range i.ApplicationCommandData().Options {
+		switch opt.Name {
+		case "perpage":
+			perPage = opt.IntValue()
+		case "shared":
+			shared = opt.BoolValue()
+		default:
+			options[opt.Name] = strings.TrimSpace(opt.StringValue())
+		}
+	}
+
+	// the pages build the query again, this only reports invalid options up front
+	if _, err := buildSearchQuery(options); err != nil {
+		respondWithError(s, i.Interaction, err.Error())
+		return
+	}
+
+	// clamp perPage between 1 and 5
+	if perPage < 1 {
+		perPage = 1
+	}
+	if perPage > 5 {
+		perPage = 5
+	}
+
+	state, err := newLazyPagination("search", searchPageParams{Options: options, PerPage: int(perPage)})
+	if err != nil {
+		log.Printf("search: unable to create paginator: %v", err)
+		respondWithError(s, i.Interaction, "Could not query database.")
+		return
+	}
+	state.Shared = shared
+
+	if err := sendPaginatedResponse(s, i, state); err != nil {
+		if errors.Is(err, errNoPages) {
+			respondWithError(s, i.Interaction, "No items found for that search.")
+			return
+		}
+		log.Printf("search: query failed: %v", err)
+		respondWithError(s, i.Interaction, "Could not query database.")
+	}
+}
+
+// searchPageParams are what a /search paginator loads its pages from
+type searchPageParams struct {
+	Options map[string]string `json:"options"`
+	PerPage int               `json:"perPage"`
+}
+
+// loadSearchPage renders a page of /search results, newest first
+func loadSearchPage(raw json.RawMessage, page int) (string, int, error) {
+	var params searchPageParams
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return "", 0, err
+	}
+
+	q, err := buildSearchQuery(params.Options)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return contentsPage(q, params.PerPage, page, "\n\n", func(record *core.Record) string {
+		link := recordLink(record)
+		if link == "" {
+			return ""
+		}
+		return fmt.Sprintf("**%s**\n%s", record.GetString("title"), link)
+	})
+}
+
+// buildSearchQuery turns the /search options into a query on "contents"
+func buildSearchQuery(options map[string]string) (*contentsQuery, error) {
+	q, err := buildContentsQuery(options)
+	if err != nil {
+		return nil, err
+	}
+
+	if !q.isFiltered() {
+		return nil, fmt.Errorf("Give at least one search option.")
+	}
+
+	return q, nil
+}
+
+// buildContentsQuery turns idol, group, tag, uploader, from, to and title options into a query on "contents"
+func buildContentsQuery(options map[string]string) (*contentsQuery, error) {
+	q := newContentsQuery()
+
+	groupIDs := map[string]bool{}
+	if name := options["group"]; name != "" {
+		groupID, ok := groupMap.get(strings.ToLower(name))
+		if !ok {
+			return nil, fmt.Errorf("Unknown group: %s", name)
+		}
+		groupIDs[groupID] = true
+		q.withGroup(groupID)
+	}
+
+	if name := options["idol"]; name != "" {
+		idols, ok := idolMap.get(strings.ToLower(name))
+		if !ok {
+			return nil, fmt.Errorf("Unknown idol: %s", name)
+		}
+
+		// several idols share a name, narrow them down by group when one is given
+		var idolIDs []string
+		for _, idol := range idols {
+			if len(groupIDs) > 0 && !groupIDs[idol.Group] {
+				continue
+			}
+			idolIDs = append(idolIDs, idol.ID)
+		}
+		if len(idolIDs) == 0 {
+			return nil, fmt.Errorf("%s is not in %s", name, options["group"])
+		}
+		q.withIdol(idolIDs...)
+	}
+
+	if name := options["tag"]; name != "" {
+		tagID, ok := tagMap.get(strings.ToLower(name))
+		if !ok {
+			return nil, fmt.Errorf("Unknown tag: %s", name)
+		}
+		q.withTag(tagID)
+	}
+
+	if name := options["uploader"]; name != "" {
+		uploaderID, ok := uploaderMap.get(strings.ToLower(name))
+		if !ok {
+			return nil, fmt.Errorf("Unknown uploader: %s", name)
+		}
+		q.withUploader(uploaderID)
+	}
+
+	if value := options["from"]; value != "" {
+		from, err := time.Parse("060102", value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid from date, expected YYMMDD: %s", value)
+		}
+		q.where("date >= " + q.bind(from.Format(dateFilterLayout)))
+	}
+
+	if value := options["to"]; value != "" {
+		to, err := time.Parse("060102", value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid to date, expected YYMMDD: %s", value)
+		}
+		q.where("date < " + q.bind(to.AddDate(0, 0, 1).Format(dateFilterLayout)))
+	}
+
+	if title := options["title"]; title != "" {
+		q.where("title ~ " + q.bind(title))
+	}
+
+	return q, nil
+}
+
+// dateFilterLayout is the format Pocketbase stores date fields in
+const dateFilterLayout = "2006-01-02 15:04:05.000Z"
+
+// recordLink returns the best link to show for a "contents" record: the mirror, the kpfhd copy or the stored file
+func recordLink(record *core.Record) string {
+	if mirror := record.GetString("mirror"); mirror != "" {
+		return mirror
+	}
+	if kpfhdFile := record.GetString("kpfhdFile"); kpfhdFile != "" {
+		return kpfhdFile
+	}
+	if file := record.GetString("file"); file != "" {
+		return utils.GenerateLinkFromFilename(record.Id, file)
+	}
+	return ""
+}
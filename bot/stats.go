@@ -0,0 +1,415 @@
+package bot
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pocketbase/dbx"
+)
+
+// statsCacheTTL is how long computed stats and leaderboards are reused
+const statsCacheTTL = 10 * time.Minute
+
+// leaderboardSize is how many entries a leaderboard shows
+const leaderboardSize = 10
+
+var statsCommand = &discordgo.ApplicationCommand{
+	Name:        "stats",
+	Description: "Show archive totals, or the numbers of an uploader.",
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Name:         "uploader",
+			Description:  "Uploader name, defaults to archive totals",
+			Type:         discordgo.ApplicationCommandOptionString,
+			Autocomplete: true,
+		},
+	},
+}
+
+var leaderboardCommand = &discordgo.ApplicationCommand{
+	Name:        "leaderboard",
+	Description: "Show the top uploaders, idols or groups.",
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Name:        "category",
+			Description: "What to rank (default uploaders)",
+			Type:        discordgo.ApplicationCommandOptionString,
+			Choices: []*discordgo.ApplicationCommandOptionChoice{
+				{Name: "uploaders", Value: "uploaders"},
+				{Name: "idols", Value: "idols"},
+				{Name: "groups", Value: "groups"},
+			},
+		},
+		{
+			Name:        "period",
+			Description: "Time period to count (default this month)",
+			Type:        discordgo.ApplicationCommandOptionString,
+			Choices: []*discordgo.ApplicationCommandOptionChoice{
+				{Name: "last 7 days", Value: "week"},
+				{Name: "last 30 days", Value: "month"},
+				{Name: "last 365 days", Value: "year"},
+				{Name: "all time", Value: "all"},
+			},
+		},
+	},
+}
+
+// leaderboardPeriods maps the period choices to how far back they count, zero counts everything
+var leaderboardPeriods = map[string]struct {
+	Label string
+	Days  int
+}{
+	"week":  {"last 7 days", 7},
+	"month": {"last 30 days", 30},
+	"year":  {"last 365 days", 365},
+	"all":   {"all time", 0},
+}
+
+type cachedEmbed struct {
+	Embed     *discordgo.MessageEmbed
+	ExpiresAt time.Time
+}
+
+// statsCache stores rendered stats and leaderboard embeds by command and options
+var statsCache = make(map[string]cachedEmbed)
+var statsCacheMu sync.Mutex
+
+// rankedCount is a single row of an aggregate query
+type rankedCount struct {
+	ID    string `db:"id"`
+	Name  string `db:"name"`
+	Total int    `db:"total"`
+}
+
+func handleStatsCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	var uploader string
+	for _, opt := range i.ApplicationCommandData().Options {
+		if opt.Name == "uploader" {
+			uploader = strings.ToLower(strings.TrimSpace(opt.StringValue()))
+		}
+	}
+
+	var (
+		embed *discordgo.MessageEmbed
+		err   error
+	)
+	if uploader == "" {
+		embed, err = cachedStatsEmbed("stats", buildArchiveStatsEmbed)
+	} else {
+		uploaderID, ok := uploaderMap.get(uploader)
+		if !ok {
+			respondWithError(s, i.Interaction, fmt.Sprintf("Unknown uploader: %s", uploader))
+			return
+		}
+		embed, err = cachedStatsEmbed("stats:"+uploaderID, func() (*discordgo.MessageEmbed, error) {
+			return buildUploaderStatsEmbed(uploaderID)
+		})
+	}
+	if err != nil {
+		log.Printf("stats: query failed: %v", err)
+		respondWithError(s, i.Interaction, "Could not query database.")
+		return
+	}
+
+	respondWithEmbed(s, i, embed, "/stats")
+}
+
+func handleLeaderboardCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	category, period := "uploaders", "month"
+	for _, opt := range i.ApplicationCommandData().Options {
+		switch opt.Name {
+		case "category":
+			category = opt.StringValue()
+		case "period":
+			period = opt.StringValue()
+		}
+	}
+
+	if _, ok := leaderboardPeriods[period]; !ok {
+		respondWithError(s, i.Interaction, fmt.Sprintf("Unknown period: %s", period))
+		return
+	}
+
+	embed, err := cachedStatsEmbed("leaderboard:"+category+":"+period, func() (*discordgo.MessageEmbed, error) {
+		return buildLeaderboardEmbed(category, period)
+	})
+	if err != nil {
+		log.Printf("leaderboard: query failed: %v", err)
+		respondWithError(s, i.Interaction, "Could not query database.")
+		return
+	}
+
+	respondWithEmbed(s, i, embed, "/leaderboard")
+}
+
+// respondWithEmbed replies to a command with a single embed
+func respondWithEmbed(s *discordgo.Session, i *discordgo.InteractionCreate, embed *discordgo.MessageEmbed, command string) {
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Embeds: []*discordgo.MessageEmbed{embed},
+		},
+	})
+	if err != nil {
+		log.Printf("Error responding to %s: %v", command, err)
+	}
+}
+
+// cachedStatsEmbed returns the cached embed for key, building it again once it expired
+func cachedStatsEmbed(key string, build func() (*discordgo.MessageEmbed, error)) (*discordgo.MessageEmbed, error) {
+	statsCacheMu.Lock()
+	cached, ok := statsCache[key]
+	statsCacheMu.Unlock()
+	if ok && time.Now().Before(cached.ExpiresAt) {
+		return cached.Embed, nil
+	}
+
+	embed, err := build()
+	if err != nil {
+		return nil, err
+	}
+	embed.Timestamp = time.Now().Format(time.RFC3339)
+
+	now := time.Now()
+	statsCacheMu.Lock()
+	// every uploader has their own stats, drop the expired ones so the map doesn't keep growing
+	for k, c := range statsCache {
+		if !now.Before(c.ExpiresAt) {
+			delete(statsCache, k)
+		}
+	}
+	statsCache[key] = cachedEmbed{Embed: embed, ExpiresAt: now.Add(statsCacheTTL)}
+	statsCacheMu.Unlock()
+
+	return embed, nil
+}
+
+// buildArchiveStatsEmbed counts everything in the archive
+func buildArchiveStatsEmbed() (*discordgo.MessageEmbed, error) {
+	counts := []struct {
+		Label      string
+		Collection string
+		Expr       dbx.Expression
+	}{
+		{"Contents", "contents", dbx.HashExp{"deleted": ""}},
+		{"Sets", "contents_sets", dbx.HashExp{"deleted": ""}},
+		{"Idols", "groups_idols", nil},
+		{"Groups", "groups", nil},
+		{"Uploaders", "uploaders", nil},
+		{"Likes", "users_likes", nil},
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title: "KpopCat archive stats",
+		Color: embedColor,
+	}
+
+	for _, c := range counts {
+		var exprs []dbx.Expression
+		if c.Expr != nil {
+			exprs = append(exprs, c.Expr)
+		}
+		total, err := App.CountRecords(c.Collection, exprs...)
+		if err != nil {
+			return nil, err
+		}
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:   c.Label,
+			Value:  fmt.Sprintf("%d", total),
+			Inline: true,
+		})
+	}
+
+	var videos int
+	err := App.DB().NewQuery("SELECT COUNT(*) FROM contents WHERE deleted = '' AND filetype = 'video'").Row(&videos)
+	if err != nil {
+		return nil, err
+	}
+	embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+		Name:   "Videos",
+		Value:  fmt.Sprintf("%d", videos),
+		Inline: true,
+	})
+
+	return embed, nil
+}
+
+// buildUploaderStatsEmbed counts the uploads and likes of a single uploader
+func buildUploaderStatsEmbed(uploaderID string) (*discordgo.MessageEmbed, error) {
+	uploader, err := App.FindRecordById("uploaders", uploaderID)
+	if err != nil {
+		return nil, err
+	}
+
+	var totals struct {
+		Contents int            `db:"contents"`
+		Quality  int            `db:"quality"`
+		First    sql.NullString `db:"first"`
+		Last     sql.NullString `db:"last"`
+	}
+	err = App.DB().NewQuery(`
+		SELECT COUNT(*) AS contents, COALESCE(SUM(c.isQuality), 0) AS quality, MIN(c.created) AS first, MAX(c.created) AS last
+		FROM contents c, json_each(c.uploader) u
+		WHERE u.value = {:uploader} AND c.deleted = ''
+	`).Bind(dbx.Params{"uploader": uploaderID}).One(&totals)
+	if err != nil {
+		return nil, err
+	}
+
+	var sets, likes int
+	err = App.DB().NewQuery(`
+		SELECT COUNT(*) FROM contents_sets s, json_each(s.uploader) u
+		WHERE u.value = {:uploader} AND s.deleted = ''
+	`).Bind(dbx.Params{"uploader": uploaderID}).Row(&sets)
+	if err != nil {
+		return nil, err
+	}
+	err = App.DB().NewQuery(`
+		SELECT COUNT(*) FROM users_likes l
+		JOIN contents c ON c.id = l.content, json_each(c.uploader) u
+		WHERE u.value = {:uploader} AND c.deleted = ''
+	`).Bind(dbx.Params{"uploader": uploaderID}).Row(&likes)
+	if err != nil {
+		return nil, err
+	}
+
+	topIdols, err := topIdolsOfUploader(uploaderID)
+	if err != nil {
+		return nil, err
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title: fmt.Sprintf("Stats of %s", uploader.GetString("name")),
+		Color: embedColor,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Contents", Value: fmt.Sprintf("%d", totals.Contents), Inline: true},
+			{Name: "Sets", Value: fmt.Sprintf("%d", sets), Inline: true},
+			{Name: "Quality", Value: fmt.Sprintf("%d", totals.Quality), Inline: true},
+			{Name: "Likes received", Value: fmt.Sprintf("%d", likes), Inline: true},
+		},
+	}
+	if totals.First.Valid && totals.Last.Valid {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:   "Active",
+			Value:  fmt.Sprintf("%s – %s", dateOnly(totals.First.String), dateOnly(totals.Last.String)),
+			Inline: true,
+		})
+	}
+	if len(topIdols) > 0 {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  "Most uploaded idols",
+			Value: formatRanking(topIdols, "items"),
+		})
+	}
+
+	return embed, nil
+}
+
+// topIdolsOfUploader returns the idols an uploader posted the most
+func topIdolsOfUploader(uploaderID string) ([]rankedCount, error) {
+	var rows []rankedCount
+	err := App.DB().NewQuery(`
+		SELECT i.id AS id, i.name AS name, COUNT(*) AS total
+		FROM contents c, json_each(c.uploader) u, json_each(c.idol) ci
+		JOIN groups_idols i ON i.id = ci.value
+		WHERE u.value = {:uploader} AND c.deleted = ''
+		GROUP BY i.id
+		ORDER BY total DESC, i.name
+		LIMIT 5
+	`).Bind(dbx.Params{"uploader": uploaderID}).All(&rows)
+	return rows, err
+}
+
+// buildLeaderboardEmbed ranks uploaders, idols or groups by their contents created in the period
+func buildLeaderboardEmbed(category, period string) (*discordgo.MessageEmbed, error) {
+	p := leaderboardPeriods[period]
+	since := ""
+	if p.Days > 0 {
+		since = time.Now().UTC().AddDate(0, 0, -p.Days).Format(dateFilterLayout)
+	}
+	params := dbx.Params{"since": since, "limit": leaderboardSize}
+
+	var relation, collection string
+	switch category {
+	case "uploaders":
+		relation, collection = "uploader", "uploaders"
+	case "idols":
+		relation, collection = "idol", "groups_idols"
+	case "groups":
+		relation, collection = "group", "groups"
+	default:
+		return nil, fmt.Errorf("unknown leaderboard category: %s", category)
+	}
+
+	var rows []rankedCount
+	err := App.DB().NewQuery(fmt.Sprintf(`
+		SELECT r.id AS id, r.name AS name, COUNT(*) AS total
+		FROM contents c, json_each(c.[[%s]]) j
+		JOIN {{%s}} r ON r.id = j.value
+		WHERE c.deleted = '' AND c.created >= {:since}
+		GROUP BY r.id
+		ORDER BY total DESC, r.name
+		LIMIT {:limit}
+	`, relation, collection)).Bind(params).All(&rows)
+	if err != nil {
+		return nil, err
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:       fmt.Sprintf("Top %s", category),
+		Description: fmt.Sprintf("By contents uploaded, %s", p.Label),
+		Color:       embedColor,
+	}
+
+	if len(rows) == 0 {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "Uploads", Value: "Nothing uploaded in this period."})
+		return embed, nil
+	}
+	embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "Uploads", Value: formatRanking(rows, "items")})
+
+	// uploaders are also ranked by the likes their contents got in the period
+	if category == "uploaders" {
+		var likes []rankedCount
+		err := App.DB().NewQuery(`
+			SELECT r.id AS id, r.name AS name, COUNT(*) AS total
+			FROM users_likes l
+			JOIN contents c ON c.id = l.content, json_each(c.uploader) j
+			JOIN uploaders r ON r.id = j.value
+			WHERE c.deleted = '' AND l.created >= {:since}
+			GROUP BY r.id
+			ORDER BY total DESC, r.name
+			LIMIT {:limit}
+		`).Bind(params).All(&likes)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		if len(likes) > 0 {
+			embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "Likes received", Value: formatRanking(likes, "likes")})
+		}
+	}
+
+	return embed, nil
+}
+
+// formatRanking renders ranked rows as a numbered list
+func formatRanking(rows []rankedCount, unit string) string {
+	var sb strings.Builder
+	for idx, row := range rows {
+		fmt.Fprintf(&sb, "**%d.** %s — %d %s\n", idx+1, row.Name, row.Total, unit)
+	}
+	return sb.String()
+}
+
+// dateOnly cuts a Pocketbase datetime down to its date
+func dateOnly(datetime string) string {
+	if len(datetime) >= 10 {
+		return datetime[:10]
+	}
+	return datetime
+}
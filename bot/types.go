@@ -31,23 +31,34 @@ type Idol struct {
 }
 
 type Metadata struct {
-	MessageID     string   `json:"-"`
-	AuthorID      string   `json:"-"`
-	File          string   `json:"file"`
-	Filetype      string   `json:"filetype"`
-	Title         string   `json:"title"`
-	Idol          string   `json:"idol"`
-	Group         string   `json:"group"`
-	Tags          string   `json:"tags"`
-	Uploader      string   `json:"uploader"`
-	Date          string   `json:"date"`
-	Source        string   `json:"source"`
-	Discord       string   `json:"discord"`
-	Mirror        string   `json:"mirror"`
-	HqMirror      string   `json:"hqMirror"`
-	SetId         string   `json:"setId"`
-	RecordIds     []string `json:"-"`
-	SetResponseId string   `json:"-"`
+	MessageID     string     `json:"-"`
+	AuthorID      string     `json:"-"`
+	File          string     `json:"file"`
+	Filetype      string     `json:"filetype"`
+	ContentType   string     `json:"contenttype"`
+	Title         string     `json:"title"`
+	Idol          string     `json:"idol"`
+	Group         string     `json:"group"`
+	Tags          string     `json:"tags"` // comma separated tag names, resolved by tagIDs
+	Uploader      string     `json:"uploader"`
+	UploaderID    string     `json:"-"` // Discord user ID of the uploader
+	Date          string     `json:"date"`
+	Source        string     `json:"source"`
+	Discord       string     `json:"discord"`
+	Mirror        string     `json:"mirror"`
+	HqMirror      string     `json:"hqMirror"`
+	SetId         string     `json:"setId"`
+	DiscordRef    DiscordRef `json:"-"`
+	RecordIds     []string   `json:"-"`
+	SetResponseId string     `json:"-"`
+}
+
+// DiscordRef points at the Discord message a record was created from
+type DiscordRef struct {
+	GuildID   string
+	ChannelID string
+	MessageID string
+	AuthorID  string
 }
 
 type IdolItem struct {
@@ -61,3 +72,33 @@ type IdolGroup struct {
 	Idol  string
 	Group string
 }
+
+// GuildConfig is the per-guild bot configuration stored in "discord_guilds"
+type GuildConfig struct {
+	GuildID         string            `json:"guildId"`
+	DeletePolicy    string            `json:"deletePolicy"`
+	CuratorRoles    []string          `json:"curatorRoles"`
+	ReactionActions map[string]string `json:"reactionActions"`
+}
+
+// AuditEntry is a single moderation action recorded in "audit_logs"
+type AuditEntry struct {
+	Action    string         `json:"action"`
+	ActorID   string         `json:"actorId"`
+	GuildID   string         `json:"guildId"`
+	ChannelID string         `json:"channelId"`
+	MessageID string         `json:"messageId"`
+	Records   []string       `json:"records"`
+	Details   map[string]any `json:"details"`
+}
+
+// CommandPermission restricts who can use a command in a guild, stored in "discord_command_permissions".
+// Command "*" applies to every command of the guild.
+type CommandPermission struct {
+	GuildID     string   `json:"guildId"`
+	Command     string   `json:"command"`
+	Roles       []string `json:"roles"`
+	Permissions int64    `json:"permissions"`
+	AllowUsers  []string `json:"allowUsers"`
+	DenyUsers   []string `json:"denyUsers"`
+}
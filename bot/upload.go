@@ -0,0 +1,476 @@
+package bot
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"kcat-v3-be/bot/utils"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// uploadModalPrefix prefixes the CustomID of the /upload modal and of the button reopening it,
+// followed by the pending upload key
+const uploadModalPrefix = "upload:"
+
+// pendingUploadTTL is how long the files of an /upload wait for its modal to be submitted successfully
+const pendingUploadTTL = 15 * time.Minute
+
+// uploadAttachmentOptions are the attachment options of /upload, in order
+var uploadAttachmentOptions = []string{"file", "file2", "file3", "file4", "file5"}
+
+var uploadCommand = &discordgo.ApplicationCommand{
+	Name:        "upload",
+	Description: "Upload files to KpopCat, the details are asked in a form.",
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Name:        "file",
+			Description: "File to upload",
+			Type:        discordgo.ApplicationCommandOptionAttachment,
+			Required:    true,
+		},
+		{
+			Name:        "file2",
+			Description: "Another file, uploads of several files become a set",
+			Type:        discordgo.ApplicationCommandOptionAttachment,
+		},
+		{
+			Name:        "file3",
+			Description: "Another file",
+			Type:        discordgo.ApplicationCommandOptionAttachment,
+		},
+		{
+			Name:        "file4",
+			Description: "Another file",
+			Type:        discordgo.ApplicationCommandOptionAttachment,
+		},
+		{
+			Name:        "file5",
+			Description: "Another file",
+			Type:        discordgo.ApplicationCommandOptionAttachment,
+		},
+		{
+			Name:        "links",
+			Description: "Media links to upload as well (imgur, catbox, pixeldrain, ...)",
+			Type:        discordgo.ApplicationCommandOptionString,
+		},
+		{
+			// modals hold at most five inputs, the date is the one asked up front
+			Name:        "date",
+			Description: "Date of the content (YYMMDD), defaults to today",
+			Type:        discordgo.ApplicationCommandOptionString,
+		},
+	},
+}
+
+// pendingUpload holds the files of an /upload until its modal is submitted
+type pendingUpload struct {
+	UserID      string
+	Attachments []*discordgo.MessageAttachment
+	MediaLinks  []MediaLink
+	HqMirror    string
+	Date        string
+	Values      map[string]string // details of the last rejected submit, filled back in when the modal reopens
+	CreatedAt   time.Time
+}
+
+var pendingUploads = make(map[string]pendingUpload)
+var pendingUploadsMu sync.Mutex
+
+// handleUploadCommand checks the files of an /upload and opens the modal asking for its details
+func handleUploadCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	data := i.ApplicationCommandData()
+
+	upload := pendingUpload{
+		UserID:    getUserID(i),
+		CreatedAt: time.Now(),
+	}
+
+	options := make(map[string]*discordgo.ApplicationCommandInteractionDataOption)
+	for _, opt := range data.Options {
+		options[opt.Name] = opt
+	}
+
+	for _, name := range uploadAttachmentOptions {
+		opt, ok := options[name]
+		if !ok || data.Resolved == nil {
+			continue
+		}
+		attachmentID, _ := opt.Value.(string)
+		if attach, ok := data.Resolved.Attachments[attachmentID]; ok {
+			upload.Attachments = append(upload.Attachments, attach)
+		}
+	}
+
+	if opt, ok := options["links"]; ok {
+		links := opt.StringValue()
+		upload.MediaLinks = findMediaLinks(links)
+		if len(upload.MediaLinks) == 0 {
+			respondEphemeral(s, i.Interaction, "None of the links can be uploaded, use imgur, catbox, pixeldrain, redgifs, twitter or direct file links.")
+			return
+		}
+		upload.HqMirror = pixeldrainRegexp.FindString(links)
+	}
+
+	if len(upload.Attachments) == 0 && len(upload.MediaLinks) == 0 {
+		respondEphemeral(s, i.Interaction, "Attach at least one file.")
+		return
+	}
+
+	if opt, ok := options["date"]; ok {
+		upload.Date = strings.TrimSpace(opt.StringValue())
+		if err := validateUploadDate(upload.Date); err != nil {
+			respondEphemeral(s, i.Interaction, err.Error())
+			return
+		}
+	}
+
+	key := utils.GenerateRandomString(15)
+
+	pendingUploadsMu.Lock()
+	cleanupOldPendingUploads()
+	pendingUploads[key] = upload
+	pendingUploadsMu.Unlock()
+
+	if err := s.InteractionRespond(i.Interaction, uploadModal(key, nil)); err != nil {
+		log.Printf("Error responding to /upload: %v", err)
+	}
+}
+
+// uploadModal builds the modal asking for the details of a pending upload, filled with values when given
+func uploadModal(key string, values map[string]string) *discordgo.InteractionResponse {
+	return &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseModal,
+		Data: &discordgo.InteractionResponseData{
+			CustomID: uploadModalPrefix + key,
+			Title:    "Upload to KpopCat",
+			Components: []discordgo.MessageComponent{
+				prefilledTextInput("title", "Title", "Defaults to \"<idol> from <group>\"", values["title"], false),
+				prefilledTextInput("idol", "Idols", "Comma separated, e.g. Yujin, Wonyoung", values["idol"], true),
+				prefilledTextInput("group", "Groups", "Comma separated, e.g. IVE", values["group"], true),
+				prefilledTextInput("tags", "Tags", "Comma separated, optional", values["tags"], false),
+				prefilledTextInput("source", "Source", "Link to the original video, optional", values["source"], false),
+			},
+		},
+	}
+}
+
+// uploadTextInput builds a single line input of the /upload modal
+func uploadTextInput(customID, label, placeholder string, required bool) discordgo.ActionsRow {
+	return discordgo.ActionsRow{
+		Components: []discordgo.MessageComponent{
+			discordgo.TextInput{
+				CustomID:    customID,
+				Label:       label,
+				Style:       discordgo.TextInputShort,
+				Placeholder: placeholder,
+				Required:    required,
+				MaxLength:   200,
+			},
+		},
+	}
+}
+
+// pendingUploadFor returns the pending upload of a modal or retry button, false when it expired
+// or belongs to someone else
+func pendingUploadFor(i *discordgo.InteractionCreate, key string) (pendingUpload, bool) {
+	pendingUploadsMu.Lock()
+	defer pendingUploadsMu.Unlock()
+
+	upload, ok := pendingUploads[key]
+	if !ok || upload.UserID != getUserID(i) || time.Since(upload.CreatedAt) > pendingUploadTTL {
+		return pendingUpload{}, false
+	}
+	return upload, true
+}
+
+// handleUploadRetry reopens the modal of an upload whose details were rejected, with the rejected values
+func handleUploadRetry(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	key := strings.TrimPrefix(i.MessageComponentData().CustomID, uploadModalPrefix)
+
+	upload, ok := pendingUploadFor(i, key)
+	if !ok {
+		respondEphemeral(s, i.Interaction, "This upload expired, run /upload again.")
+		return
+	}
+
+	if err := s.InteractionRespond(i.Interaction, uploadModal(key, upload.Values)); err != nil {
+		log.Printf("Error reopening the /upload modal: %v", err)
+	}
+}
+
+// handleUploadModal validates the submitted /upload details and ingests the files
+func handleUploadModal(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	data := i.ModalSubmitData()
+	key := strings.TrimPrefix(data.CustomID, uploadModalPrefix)
+
+	upload, ok := pendingUploadFor(i, key)
+	if !ok {
+		respondEphemeral(s, i.Interaction, "This upload expired, run /upload again.")
+		return
+	}
+
+	values := modalValues(data)
+	metadata, err := buildUploadMetadata(values)
+	if err != nil {
+		// the files stay pending, so the details can be fixed without attaching them again
+		pendingUploadsMu.Lock()
+		if pending, ok := pendingUploads[key]; ok {
+			pending.Values = values
+			pendingUploads[key] = pending
+		}
+		pendingUploadsMu.Unlock()
+
+		respondUploadRejected(s, i, key, err)
+		return
+	}
+
+	// a submit only goes through once, a second one finds the upload gone
+	pendingUploadsMu.Lock()
+	_, ok = pendingUploads[key]
+	delete(pendingUploads, key)
+	pendingUploadsMu.Unlock()
+	if !ok {
+		respondEphemeral(s, i.Interaction, "This upload expired, run /upload again.")
+		return
+	}
+
+	metadata.Date = upload.Date
+	if metadata.Date == "" {
+		metadata.Date = "today"
+	}
+	metadata.HqMirror = upload.HqMirror
+
+	user := i.User
+	if i.Member != nil && i.Member.User != nil {
+		user = i.Member.User
+	}
+
+	// downloads take longer than the 3 seconds Discord waits for a response
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+	if err != nil {
+		log.Printf("Error responding to /upload modal: %v", err)
+		return
+	}
+
+	// the records point at the confirmation message, so edits and deletes of it apply to them
+	reply, err := s.InteractionResponse(i.Interaction)
+	if err != nil {
+		log.Printf("upload: unable to get the response message: %v", err)
+		return
+	}
+
+	metadata.Uploader = user.Username
+	metadata.UploaderID = user.ID
+	metadata.Discord = utils.GenerateDiscordMessageLink(i.GuildID, i.ChannelID, reply.ID)
+	metadata.DiscordRef = DiscordRef{
+		GuildID:   i.GuildID,
+		ChannelID: i.ChannelID,
+		MessageID: reply.ID,
+		AuthorID:  user.ID,
+	}
+
+	resolvedMedia, resolveErr := resolveMediaLinks(upload.MediaLinks)
+	totalItems := len(upload.Attachments) + len(resolvedMedia)
+
+	if totalItems > 1 {
+		metadata.SetId = utils.GenerateRandomString(15)
+		if err := createSetRecord(metadata); err != nil {
+			log.Printf("upload: unable to create set: %v", err)
+			editUploadResponse(s, i, "❌ Could not create the set, nothing was uploaded.", nil, nil)
+			return
+		}
+	}
+
+	recordIDs := ingestMedia(metadata, upload.Attachments, resolvedMedia)
+	if metadata.SetId != "" && len(recordIDs) == 0 {
+		softDeleteSetIfEmpty(metadata.SetId)
+	}
+
+	content, embeds, components := uploadSummary(metadata, recordIDs, totalItems)
+	if notice := unresolvedNotice(resolveErr); notice != "" {
+		content += "\n⚠️ " + notice
+	}
+	editUploadResponse(s, i, content, embeds, components)
+}
+
+// respondUploadRejected tells the user why their upload details were rejected, with a button reopening the modal
+func respondUploadRejected(s *discordgo.Session, i *discordgo.InteractionCreate, key string, reason error) {
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: fmt.Sprintf("%s\nNothing was uploaded yet, fix the details to upload the files.", reason),
+			Flags:   discordgo.MessageFlagsEphemeral,
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.Button{
+							Label:    "Fix details",
+							Style:    discordgo.PrimaryButton,
+							CustomID: uploadModalPrefix + key,
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("Error responding to /upload modal: %v", err)
+	}
+}
+
+// modalValues returns the trimmed values of the text inputs of a submitted modal by their CustomID
+func modalValues(data discordgo.ModalSubmitInteractionData) map[string]string {
+	values := make(map[string]string)
+	for _, component := range data.Components {
+		row, ok := component.(*discordgo.ActionsRow)
+		if !ok {
+			continue
+		}
+		for _, c := range row.Components {
+			if input, ok := c.(*discordgo.TextInput); ok {
+				values[input.CustomID] = strings.TrimSpace(input.Value)
+			}
+		}
+	}
+	return values
+}
+
+// buildUploadMetadata validates the /upload modal values against the known idols, groups and tags
+func buildUploadMetadata(values map[string]string) (Metadata, error) {
+	metadata := Metadata{
+		Title:  values["title"],
+		Idol:   values["idol"],
+		Group:  values["group"],
+		Source: values["source"],
+	}
+
+	var problems []string
+
+	groupIDs := make(map[string]bool)
+	for _, name := range convertToStringSlice(metadata.Group) {
+		groupID, ok := groupMap.get(name)
+		if !ok {
+			problems = append(problems, fmt.Sprintf("Unknown group: %s", name))
+			continue
+		}
+		groupIDs[groupID] = true
+	}
+
+	idols := convertToStringSlice(metadata.Idol)
+	if len(idols) == 0 || len(convertToStringSlice(metadata.Group)) == 0 {
+		problems = append(problems, "Give at least one idol and group.")
+	}
+	for _, name := range idols {
+		if _, ok := idolMap.get(name); !ok {
+			problems = append(problems, fmt.Sprintf("Unknown idol: %s", name))
+		} else if _, ok := getIdolIDByGroup(name, groupIDs); !ok && len(groupIDs) > 0 {
+			problems = append(problems, fmt.Sprintf("%s is not in %s", name, metadata.Group))
+		}
+	}
+
+	// tags are kept as names like in posts, parseMetadataToMap resolves them
+	metadata.Tags = values["tags"]
+	for _, name := range convertToStringSlice(metadata.Tags) {
+		if _, ok := tagMap.get(name); !ok {
+			problems = append(problems, fmt.Sprintf("Unknown tag: %s", name))
+		}
+	}
+
+	if err := validateSourceLink(metadata.Source); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if len(problems) > 0 {
+		return Metadata{}, fmt.Errorf("%s", strings.Join(problems, "\n"))
+	}
+
+	if metadata.Title == "" {
+		metadata.Title = metadata.Idol + " from " + metadata.Group
+	}
+
+	return metadata, nil
+}
+
+// validateSourceLink accepts an empty source or an http(s) link
+func validateSourceLink(source string) error {
+	if source == "" {
+		return nil
+	}
+	if u, err := url.ParseRequestURI(source); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("Source is not a link: %s", source)
+	}
+	return nil
+}
+
+// validateUploadDate accepts the same dates as the message metadata: YYMMDD, "now" or "today"
+func validateUploadDate(date string) error {
+	if date == "" || date == "now" || date == "today" {
+		return nil
+	}
+	if _, err := time.Parse("060102", date); err != nil || len(date) != 6 {
+		return fmt.Errorf("Invalid date, expected YYMMDD: %s", date)
+	}
+	return nil
+}
+
+// uploadSummary renders what an /upload created: the set embed for several items, the item itself otherwise
+func uploadSummary(metadata Metadata, recordIDs []string, totalItems int) (string, []*discordgo.MessageEmbed, []discordgo.MessageComponent) {
+	if len(recordIDs) == 0 {
+		return "❌ None of the files could be uploaded.", nil, nil
+	}
+
+	records, err := App.FindRecordsByIds("contents", recordIDs)
+	if err != nil || len(records) == 0 {
+		log.Printf("upload: unable to load the uploaded records: %v", err)
+		return fmt.Sprintf("✅ Uploaded %d of %d files.", len(recordIDs), totalItems), nil, nil
+	}
+
+	content := "✅ Uploaded"
+	if failed := totalItems - len(recordIDs); failed > 0 {
+		content = fmt.Sprintf("⚠️ Uploaded, but %d of %d files could not be uploaded.", failed, totalItems)
+	}
+
+	if metadata.SetId == "" {
+		link, embeds, components := contentResponse(records[0])
+		return content + " " + link, embeds, components
+	}
+
+	set, err := App.FindRecordById("contents_sets", metadata.SetId)
+	if err != nil {
+		log.Printf("upload: unable to load the uploaded set: %v", err)
+		return fmt.Sprintf("%s: %s/set/%s", content, siteURL, metadata.SetId), nil, nil
+	}
+	expandForEmbed(records...)
+
+	url := fmt.Sprintf("%s/set/%s", siteURL, metadata.SetId)
+	return content, []*discordgo.MessageEmbed{setEmbed(set, records)}, []discordgo.MessageComponent{siteButton(url)}
+}
+
+// editUploadResponse replaces the deferred /upload response
+func editUploadResponse(s *discordgo.Session, i *discordgo.InteractionCreate, content string, embeds []*discordgo.MessageEmbed, components []discordgo.MessageComponent) {
+	_, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+		Content:    &content,
+		Embeds:     &embeds,
+		Components: &components,
+	})
+	if err != nil {
+		log.Printf("upload: response edit failed: %v", err)
+	}
+}
+
+// cleanupOldPendingUploads drops uploads whose modal was never submitted, pendingUploadsMu must be held
+func cleanupOldPendingUploads() {
+	cutoff := time.Now().Add(-pendingUploadTTL)
+	for key, upload := range pendingUploads {
+		if upload.CreatedAt.Before(cutoff) {
+			delete(pendingUploads, key)
+		}
+	}
+}
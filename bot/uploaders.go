@@ -0,0 +1,226 @@
+package bot
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// uploaderIDs returns the uploader record IDs for the metadata.
+// Posts from Discord are keyed by the author's user ID, metadata without one falls back to names.
+func (m Metadata) uploaderIDs() ([]string, error) {
+	if m.UploaderID != "" {
+		id, err := lookupOrCreateDiscordUploader(m.UploaderID, m.Uploader)
+		if err != nil {
+			return nil, err
+		}
+		return []string{id}, nil
+	}
+
+	var ids []string
+	for _, name := range convertToStringSlice(m.Uploader) {
+		id, err := lookupOrCreateUploader(name)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// lookupOrCreateDiscordUploader returns the uploader of a Discord user, keeping its name in sync.
+// Uploaders created before Discord IDs were stored are claimed by matching their name.
+func lookupOrCreateDiscordUploader(discordID, username string) (string, error) {
+	name := strings.ToLower(strings.TrimSpace(username))
+
+	// looked up in the database rather than a cache, the unique index must never be hit
+	record, err := App.FindFirstRecordByFilter("uploaders", "discordId = {:discordId}", dbx.Params{"discordId": discordID})
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+
+	if record == nil {
+		if id, found := uploaderMap.get(name); found {
+			candidate, err := App.FindRecordById("uploaders", id)
+			// a name already claimed by another Discord user means this is a new uploader
+			if err == nil && candidate.GetString("discordId") == "" {
+				record = candidate
+			}
+		}
+	}
+
+	if record == nil {
+		collection, err := App.FindCollectionByNameOrId("uploaders")
+		if err != nil {
+			slog.Error("ERROR FINDING COLLECTION", "MSG", err)
+			return "", err
+		}
+		record = core.NewRecord(collection)
+	}
+
+	oldName := record.GetString("name")
+	changed := record.IsNew() || oldName != name || record.GetString("discordId") != discordID
+
+	record.Set("name", name)
+	record.Set("discordId", discordID)
+	if linkUploaderToUser(record) {
+		changed = true
+	}
+
+	if changed {
+		if err := App.Save(record); err != nil {
+			slog.Error("ERROR SAVING RECORD", "MSG", err)
+			return "", err
+		}
+	}
+
+	uploaderMap.update(func(uploaders map[string]string) {
+		if oldName != name && uploaders[oldName] == record.Id {
+			delete(uploaders, oldName)
+		}
+		// a name taken by another uploader keeps pointing there for name-based credits
+		if _, taken := uploaders[name]; !taken {
+			uploaders[name] = record.Id
+		}
+	})
+
+	return record.Id, nil
+}
+
+// linkUploaderToUser sets the "user" relation of an uploader when a web user has logged in
+// with the same Discord account. It reports whether the record was changed.
+func linkUploaderToUser(record *core.Record) bool {
+	discordID := record.GetString("discordId")
+	if discordID == "" || record.GetString("user") != "" {
+		return false
+	}
+
+	externalAuth, err := App.FindFirstExternalAuthByExpr(dbx.HashExp{
+		"collectionRef": "_pb_users_auth_",
+		"provider":      "discord",
+		"providerId":    discordID,
+	})
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			slog.Error("UNABLE TO LOOK UP EXTERNAL AUTH", "MSG", err)
+		}
+		return false
+	}
+
+	record.Set("user", externalAuth.RecordRef())
+	return true
+}
+
+// LinkUploaderToUser links the uploader of a Discord user to the web user that logged in with
+// the same Discord account. Uploaders already linked to a user are left untouched.
+func LinkUploaderToUser(app core.App, userID, discordID string) error {
+	record, err := app.FindFirstRecordByFilter("uploaders", "discordId = {:discordId}", dbx.Params{"discordId": discordID})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil // never uploaded anything from Discord
+		}
+		return err
+	}
+
+	if record.GetString("user") != "" {
+		return nil
+	}
+
+	record.Set("user", userID)
+	return app.Save(record)
+}
+
+// FindDuplicateUploaders groups uploaders whose names only differ by case or surrounding whitespace
+func FindDuplicateUploaders(app core.App) ([][]*core.Record, error) {
+	records, err := app.FindRecordsByFilter("uploaders", "", "created", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string][]*core.Record)
+	var names []string
+	for _, record := range records {
+		name := strings.ToLower(strings.TrimSpace(record.GetString("name")))
+		if _, exists := byName[name]; !exists {
+			names = append(names, name)
+		}
+		byName[name] = append(byName[name], record)
+	}
+
+	var duplicates [][]*core.Record
+	for _, name := range names {
+		if len(byName[name]) > 1 {
+			duplicates = append(duplicates, byName[name])
+		}
+	}
+
+	return duplicates, nil
+}
+
+// MergeUploaders moves every content, set and account link of the duplicate uploaders
+// onto the kept uploader and deletes the duplicates, all in a single transaction.
+// Uploaders linked to different Discord accounts are never merged.
+func MergeUploaders(app core.App, keepID string, duplicateIDs []string) error {
+	return app.RunInTransaction(func(txApp core.App) error {
+		keep, err := txApp.FindRecordById("uploaders", keepID)
+		if err != nil {
+			return fmt.Errorf("uploader to keep not found: %w", err)
+		}
+
+		for _, duplicateID := range duplicateIDs {
+			if duplicateID == keepID {
+				continue
+			}
+
+			duplicate, err := txApp.FindRecordById("uploaders", duplicateID)
+			if err != nil {
+				return fmt.Errorf("duplicate uploader %s not found: %w", duplicateID, err)
+			}
+
+			// two Discord accounts are two people, merging would credit one with the other's uploads
+			keepDiscordID, duplicateDiscordID := keep.GetString("discordId"), duplicate.GetString("discordId")
+			if keepDiscordID != "" && duplicateDiscordID != "" && keepDiscordID != duplicateDiscordID {
+				return fmt.Errorf("uploaders %s and %s belong to different Discord accounts (%s and %s)",
+					keep.Id, duplicate.Id, keepDiscordID, duplicateDiscordID)
+			}
+
+			for _, collection := range []string{"contents", "contents_sets"} {
+				records, err := txApp.FindRecordsByFilter(collection, "uploader:each ?= {:id}", "", 0, 0, dbx.Params{"id": duplicateID})
+				if err != nil {
+					return err
+				}
+				for _, record := range records {
+					record.Set("uploader-", duplicateID)
+					record.Set("uploader+", keepID)
+					// only the relation moves, older records that no longer pass validation must not stop the merge
+					if err := txApp.SaveNoValidate(record); err != nil {
+						return err
+					}
+				}
+			}
+
+			if keep.GetString("discordId") == "" {
+				keep.Set("discordId", duplicate.GetString("discordId"))
+			}
+			if keep.GetString("user") == "" {
+				keep.Set("user", duplicate.GetString("user"))
+			}
+			if duplicate.GetBool("isFeatured") {
+				keep.Set("isFeatured", true)
+			}
+
+			// delete first, the unique discordId index would reject the kept record otherwise
+			if err := txApp.Delete(duplicate); err != nil {
+				return err
+			}
+		}
+
+		return txApp.Save(keep)
+	})
+}
@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"context"
+	"sync"
+)
+
+// Inflight counts background jobs in progress so shutdown can wait for them.
+// Once Wait is called no new job can start. The zero value is ready to use.
+type Inflight struct {
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	closing bool
+}
+
+// Start registers a new job, it returns false when shutting down and the job must not run
+func (f *Inflight) Start() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closing {
+		return false
+	}
+	f.wg.Add(1)
+	return true
+}
+
+// Done marks a job started with Start as finished
+func (f *Inflight) Done() {
+	f.wg.Done()
+}
+
+// Wait stops new jobs from starting and waits for the running ones, or until ctx is done
+func (f *Inflight) Wait(ctx context.Context) error {
+	f.mu.Lock()
+	f.closing = true
+	f.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
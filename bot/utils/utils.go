@@ -3,6 +3,7 @@ package utils
 import (
 	"fmt"
 	"math/rand"
+	"regexp"
 	"strings"
 	"time"
 
@@ -11,6 +12,8 @@ import (
 
 const charset = "abcdefghijklmnopqrstuvwxyz0123456789"
 
+var discordMessageLinkRegexp = regexp.MustCompile(`^https?://(?:(?:ptb|canary)\.)?discord(?:app)?\.com/channels/([0-9]+|@me)/([0-9]+)/([0-9]+)$`)
+
 func GenerateRandomString(length int) string {
 	// Create a new source and rand
 	src := rand.NewSource(time.Now().UnixNano())
@@ -40,3 +43,17 @@ func GenerateLinkFromFilename(recordID string, filename string) string {
 	link := fmt.Sprintf("https://kcat.pics/v1/%s/%s", recordID, filename)
 	return link
 }
+
+func GenerateDiscordMessageLink(guildID string, channelID string, messageID string) string {
+	link := fmt.Sprintf("https://discord.com/channels/%s/%s/%s", guildID, channelID, messageID)
+	return link
+}
+
+// ParseDiscordMessageLink extracts the guild, channel and message IDs from a message link
+func ParseDiscordMessageLink(link string) (guildID string, channelID string, messageID string, ok bool) {
+	matches := discordMessageLinkRegexp.FindStringSubmatch(strings.TrimSpace(link))
+	if matches == nil {
+		return "", "", "", false
+	}
+	return matches[1], matches[2], matches[3], true
+}
@@ -2,9 +2,12 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"kcat-v3-be/bot"
+	"kcat-v3-be/bot/utils"
 	"log"
 	"mime/multipart"
 	"net/http"
@@ -14,6 +17,7 @@ import (
 	"github.com/pocketbase/pocketbase"
 	"github.com/pocketbase/pocketbase/core"
 	"github.com/pocketbase/pocketbase/tools/filesystem"
+	"github.com/spf13/cobra"
 )
 
 // CONFIGURATION
@@ -21,8 +25,14 @@ const (
 	WORKER_URL    = "https://assistant-worked-intake-admission.trycloudflare.com/convert-webp"
 	WORKER_SECRET = "super-secret-password-123"
 	COLLECTION    = "contents" // Change to your actual collection name
+
+	// How long shutdown waits for ingests and conversions before cutting them off
+	SHUTDOWN_TIMEOUT = 20 * time.Second
 )
 
+// conversions are the webp conversions in progress, shutdown waits for them
+var conversions utils.Inflight
+
 func main() {
 	// 1. Set output to Standard Out (Railway captures this)
 	log.SetOutput(os.Stdout)
@@ -32,15 +42,20 @@ func main() {
 
 	app := pocketbase.New()
 
-	// Start Discord bot in a goroutine after Pocketbase is initialized
-	go func() {
-		// Wait for Pocketbase to fully initialize
-		time.Sleep(2 * time.Second)
-		log.Println("🤖 Starting Discord bot...")
-		if err := bot.Start(app); err != nil {
-			log.Println("❌ Failed to start Discord bot:", err)
-		}
-	}()
+	// Cancelled when conversions outlive the shutdown deadline
+	conversionCtx, cancelConversions := context.WithCancel(context.Background())
+
+	// Start the Discord bot once the app is bootstrapped and serving, console commands don't start it.
+	// Connecting takes a few seconds, so it doesn't hold up the server.
+	app.OnServe().BindFunc(func(e *core.ServeEvent) error {
+		go func() {
+			log.Println("🤖 Starting Discord bot...")
+			if err := bot.Start(app); err != nil {
+				log.Println("❌ Failed to start Discord bot:", err)
+			}
+		}()
+		return e.Next()
+	})
 
 	// Handler function for file upload events
 	handleConversion := func(e *core.RecordEvent) error {
@@ -65,10 +80,20 @@ func main() {
 		filename := record.GetString("file")
 		collectionId := record.Collection().Id
 
-		// 2. Async Processing
+		// 2. Async Processing, unless shutting down
+		if !conversions.Start() {
+			log.Println("⚠️ Shutting down, conversion skipped for:", recordId)
+			return e.Next()
+		}
 		go func(recId, colId, fName string) {
+			defer conversions.Done()
+
 			// Wait for R2 consistency (optional but recommended)
-			time.Sleep(2 * time.Second)
+			select {
+			case <-time.After(2 * time.Second):
+			case <-conversionCtx.Done():
+				return
+			}
 			log.Printf("🔄 Starting conversion for %s...", recId)
 
 			// Initialize Filesystem (Connect to R2/Local)
@@ -90,7 +115,7 @@ func main() {
 			defer r2File.Close()
 
 			// Send to Laptop Worker
-			convertedBytes, err := sendToWorker(r2File, fName)
+			convertedBytes, err := sendToWorker(conversionCtx, r2File, fName)
 			if err != nil {
 				log.Println("❌ Worker failed:", err)
 				return
@@ -127,13 +152,155 @@ func main() {
 	// Register hooks
 	app.OnRecordAfterCreateSuccess(COLLECTION).BindFunc(handleConversion)
 
+	app.RootCmd.AddCommand(uploadersCommand(app))
+
+	// Discord login for the website
+	app.OnBootstrap().BindFunc(func(e *core.BootstrapEvent) error {
+		if err := e.Next(); err != nil {
+			return err
+		}
+		return configureDiscordOAuth2(e.App)
+	})
+	app.OnRecordAuthWithOAuth2Request("users").BindFunc(linkDiscordUploader)
+
+	// Close the Discord session and drain ingests and conversions on shutdown
+	app.OnTerminate().BindFunc(func(e *core.TerminateEvent) error {
+		ctx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+		defer cancel()
+
+		if err := bot.Stop(ctx); err != nil {
+			log.Println("❌ Failed to stop Discord bot:", err)
+		}
+
+		if err := conversions.Wait(ctx); err != nil {
+			log.Println("❌ Conversions still running at shutdown, cancelling them:", err)
+		}
+		cancelConversions()
+
+		return e.Next()
+	})
+
 	if err := app.Start(); err != nil {
 		log.Fatal(err)
 	}
 }
 
+// configureDiscordOAuth2 enables Discord as an OAuth2 provider of the "users" collection
+// using the DISCORD_CLIENT_ID and DISCORD_CLIENT_SECRET environment variables.
+// The collection is only saved when the provider config actually changed.
+func configureDiscordOAuth2(app core.App) error {
+	clientID := os.Getenv("DISCORD_CLIENT_ID")
+	clientSecret := os.Getenv("DISCORD_CLIENT_SECRET")
+	if clientID == "" || clientSecret == "" {
+		log.Println("⚠️ DISCORD_CLIENT_ID or DISCORD_CLIENT_SECRET not set, Discord login stays as configured")
+		return nil
+	}
+
+	users, err := app.FindCollectionByNameOrId("users")
+	if err != nil {
+		return err
+	}
+
+	provider := core.OAuth2ProviderConfig{
+		Name:         "discord",
+		ClientId:     clientID,
+		ClientSecret: clientSecret,
+	}
+
+	found := false
+	for i, existing := range users.OAuth2.Providers {
+		if existing.Name != provider.Name {
+			continue
+		}
+		found = true
+		if existing.ClientId == clientID && existing.ClientSecret == clientSecret && users.OAuth2.Enabled {
+			return nil
+		}
+		users.OAuth2.Providers[i].ClientId = clientID
+		users.OAuth2.Providers[i].ClientSecret = clientSecret
+	}
+	if !found {
+		users.OAuth2.Providers = append(users.OAuth2.Providers, provider)
+	}
+	users.OAuth2.Enabled = true
+
+	if err := app.Save(users); err != nil {
+		return err
+	}
+
+	log.Println("✅ Discord OAuth2 provider configured")
+	return nil
+}
+
+// linkDiscordUploader links a user logging in with Discord to the uploader with the same Discord ID,
+// so uploaders can manage their own uploads on the site. Runs on every Discord login since
+// the uploader record may only appear after the first one.
+func linkDiscordUploader(e *core.RecordAuthWithOAuth2RequestEvent) error {
+	if err := e.Next(); err != nil {
+		return err
+	}
+
+	if e.ProviderName != "discord" || e.Record == nil || e.OAuth2User == nil {
+		return nil
+	}
+
+	// a failed link must never block the login itself
+	if err := bot.LinkUploaderToUser(e.App, e.Record.Id, e.OAuth2User.Id); err != nil {
+		log.Println("❌ Could not link uploader to user:", err)
+	}
+
+	return nil
+}
+
+// Console commands to clean up duplicate uploader records:
+//
+//	./myapp uploaders duplicates
+//	./myapp uploaders merge <keepId> <duplicateId>...
+func uploadersCommand(app *pocketbase.PocketBase) *cobra.Command {
+	command := &cobra.Command{
+		Use:   "uploaders",
+		Short: "Manage uploader records",
+	}
+
+	command.AddCommand(&cobra.Command{
+		Use:   "duplicates",
+		Short: "List uploaders whose names only differ by case or whitespace",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			duplicates, err := bot.FindDuplicateUploaders(app)
+			if err != nil {
+				return err
+			}
+
+			for _, group := range duplicates {
+				fmt.Println(group[0].GetString("name") + ":")
+				for _, record := range group {
+					fmt.Printf("  %s  discordId=%q  user=%q  created=%s\n",
+						record.Id, record.GetString("discordId"), record.GetString("user"), record.GetDateTime("created"))
+				}
+			}
+			fmt.Printf("%d duplicate group(s)\n", len(duplicates))
+			return nil
+		},
+	})
+
+	command.AddCommand(&cobra.Command{
+		Use:   "merge <keepId> <duplicateId>...",
+		Short: "Move the contents and sets of duplicate uploaders onto one uploader and delete the duplicates",
+		Args:  cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := bot.MergeUploaders(app, args[0], args[1:]); err != nil {
+				return err
+			}
+			fmt.Printf("Merged %d uploader(s) into %s\n", len(args)-1, args[0])
+			return nil
+		},
+	})
+
+	return command
+}
+
 // Helper: Sends file to your laptop and returns the converted bytes
-func sendToWorker(fileReader io.Reader, filename string) ([]byte, error) {
+func sendToWorker(ctx context.Context, fileReader io.Reader, filename string) ([]byte, error) {
 	body := &bytes.Buffer{}
 	writer := multipart.NewWriter(body)
 
@@ -146,7 +313,7 @@ func sendToWorker(fileReader io.Reader, filename string) ([]byte, error) {
 	}
 	writer.Close()
 
-	req, err := http.NewRequest("POST", WORKER_URL, body)
+	req, err := http.NewRequestWithContext(ctx, "POST", WORKER_URL, body)
 	if err != nil {
 		return nil, err
 	}